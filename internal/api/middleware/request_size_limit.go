@@ -0,0 +1,65 @@
+// Package middleware provides HTTP middleware components for the CLI Proxy API server.
+// This file contains the request size limit middleware that rejects oversized bodies
+// before they reach the request logger, the handlers, or any translator.
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRequestBodyBytes holds the configured request body limit in bytes. <= 0 disables the limit.
+var maxRequestBodyBytes atomic.Int64
+
+// SetMaxRequestBodyBytes sets the maximum accepted request body size in bytes.
+// A value <= 0 disables the limit.
+func SetMaxRequestBodyBytes(limit int64) {
+	maxRequestBodyBytes.Store(limit)
+}
+
+// RequestSizeLimitMiddleware creates a Gin middleware that rejects request bodies larger than
+// the limit configured via SetMaxRequestBodyBytes with 413 Request Entity Too Large.
+// Bodies are read at most once up to the limit, so an oversized payload is never fully buffered.
+func RequestSizeLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := maxRequestBodyBytes.Load()
+		if limit <= 0 || c.Request.Body == nil || c.Request.Method == http.MethodGet {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			abortRequestTooLarge(c, limit)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+		_ = c.Request.Body.Close()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Invalid request: %v", err),
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+		if int64(len(body)) > limit {
+			abortRequestTooLarge(c, limit)
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+func abortRequestTooLarge(c *gin.Context, limit int64) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": gin.H{
+		"message": fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", limit),
+		"type":    "request_too_large",
+	}})
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newSizeLimitedEngine(t *testing.T, limit int64) (*gin.Engine, *string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	SetMaxRequestBodyBytes(limit)
+	t.Cleanup(func() { SetMaxRequestBodyBytes(0) })
+
+	received := new(string)
+	engine := gin.New()
+	engine.Use(RequestSizeLimitMiddleware())
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		*received = string(body)
+		c.Status(http.StatusOK)
+	})
+	return engine, received
+}
+
+func TestRequestSizeLimitMiddleware_RejectsOversizedBody(t *testing.T) {
+	engine, received := newSizeLimitedEngine(t, 16)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(strings.Repeat("x", 64)))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", w.Code)
+	}
+	if *received != "" {
+		t.Error("Handler should not run for oversized bodies")
+	}
+
+	// Unknown content length (chunked) is still bounded by the limit.
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", io.NopCloser(strings.NewReader(strings.Repeat("x", 64))))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413 for chunked body, got %d", w.Code)
+	}
+}
+
+func TestRequestSizeLimitMiddleware_AllowsBodyWithinLimit(t *testing.T) {
+	engine, received := newSizeLimitedEngine(t, 16)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"a":1}`))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if *received != `{"a":1}` {
+		t.Errorf("Expected body to reach handler unchanged, got %q", *received)
+	}
+}
@@ -28,6 +28,9 @@ import (
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/managementasset"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/misc"
+	antigravityclaude "github.com/router-for-me/CLIProxyAPI/v6/internal/translator/antigravity/claude"
+	antigravityopenai "github.com/router-for-me/CLIProxyAPI/v6/internal/translator/antigravity/openai/chat-completions"
+	geminicommon "github.com/router-for-me/CLIProxyAPI/v6/internal/translator/gemini/common"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
@@ -204,6 +207,8 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	// Add middleware
 	engine.Use(logging.GinLogrusLogger())
 	engine.Use(logging.GinLogrusRecovery())
+	middleware.SetMaxRequestBodyBytes(cfg.MaxRequestBodyBytes)
+	engine.Use(middleware.RequestSizeLimitMiddleware())
 	for _, mw := range optionState.extraMiddleware {
 		engine.Use(mw)
 	}
@@ -253,10 +258,12 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	s.applyAccessConfig(nil, cfg)
 	if authManager != nil {
 		authManager.SetRetryConfig(cfg.RequestRetry, time.Duration(cfg.MaxRetryInterval)*time.Second)
+		authManager.SetRetryBackoff(time.Duration(cfg.RetryBaseDelayMs)*time.Millisecond, time.Duration(cfg.RetryMaxDelayMs)*time.Millisecond)
 	}
 	managementasset.SetCurrentConfig(cfg)
 	auth.SetQuotaCooldownDisabled(cfg.DisableCooling)
 	misc.SetCodexInstructionsEnabled(cfg.CodexInstructionsEnabled)
+	applyTranslatorConfig(cfg)
 	// Initialize management handler
 	s.mgmt = managementHandlers.NewHandler(cfg, configFilePath, authManager)
 	if optionState.localPassword != "" {
@@ -875,6 +882,25 @@ func (s *Server) applyAccessConfig(oldCfg, newCfg *config.Config) {
 	}
 }
 
+// applyTranslatorConfig pushes the translator settings of cfg into the translator packages. The
+// setters are cheap and idempotent, so NewServer and every reload apply all of them.
+func applyTranslatorConfig(cfg *config.Config) {
+	if errSafety := geminicommon.SetSafetyThresholds(cfg.SafetyThresholds); errSafety != nil {
+		log.Errorf("invalid safety-thresholds, keeping the current thresholds: %v", errSafety)
+	}
+	antigravityclaude.SetMaxToolDeclarations(cfg.MaxToolDeclarations)
+	antigravityclaude.SetMaxRequestParts(cfg.MaxRequestParts)
+	antigravityclaude.SetMaxThinkingParts(cfg.MaxThinkingParts)
+	antigravityclaude.SetEnforceTurnAlternation(cfg.EnforceTurnAlternation)
+	antigravityclaude.SetDefaultMaxOutputTokens(cfg.DefaultMaxOutputTokens)
+	antigravityclaude.SetSanitizeTextParts(cfg.SanitizeTextParts)
+	antigravityclaude.SetMetadataLabels(cfg.MetadataLabels)
+	antigravityclaude.SetInterleavedThinkingHint(cfg.InterleavedThinkingHint)
+	antigravityclaude.SetUnsignedThinkingMode(cfg.UnsignedThinking)
+	antigravityclaude.SetRoleLessSystemInstructionModels(cfg.RoleLessSystemInstructionModels)
+	antigravityopenai.SetInstructionOrder(cfg.SystemInstructionOrder)
+}
+
 // UpdateClients updates the server's client list and configuration.
 // This method is called when the configuration or authentication tokens change.
 //
@@ -950,8 +976,28 @@ func (s *Server) UpdateClients(cfg *config.Config) {
 		}
 	}
 
+	if oldCfg == nil || oldCfg.MaxRequestBodyBytes != cfg.MaxRequestBodyBytes {
+		middleware.SetMaxRequestBodyBytes(cfg.MaxRequestBodyBytes)
+		if oldCfg != nil {
+			log.Debugf("max_request_body_bytes updated from %d to %d", oldCfg.MaxRequestBodyBytes, cfg.MaxRequestBodyBytes)
+		}
+	}
+
+	applyTranslatorConfig(cfg)
+	if oldCfg != nil {
+		log.Debug("translator settings reapplied after config reload")
+	}
+
 	if s.handlers != nil && s.handlers.AuthManager != nil {
 		s.handlers.AuthManager.SetRetryConfig(cfg.RequestRetry, time.Duration(cfg.MaxRetryInterval)*time.Second)
+		s.handlers.AuthManager.SetRetryBackoff(time.Duration(cfg.RetryBaseDelayMs)*time.Millisecond, time.Duration(cfg.RetryMaxDelayMs)*time.Millisecond)
+	}
+
+	// Drop clients built for the previous configuration, such as ones bound to an old proxy.
+	// Cached thinking signatures stay valid across reloads and are kept.
+	if oldCfg != nil {
+		util.ResetClientCaches()
+		log.Debug("client caches cleared after config reload")
 	}
 
 	// Update log level dynamically when debug flag changes
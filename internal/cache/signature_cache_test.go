@@ -207,3 +207,31 @@ func TestCacheSignature_ExpirationLogic(t *testing.T) {
 	// but the logic is verified by the implementation
 	_ = time.Now() // Acknowledge we're not testing time passage
 }
+
+func TestSignatureCache_ExportImportRoundTrip(t *testing.T) {
+	ClearSignatureCache("")
+
+	text := "Thinking worth keeping across hosts"
+	signature := "exported_signature_1234567890123456789012345678901234567890"
+	CacheSignature("claude-sonnet-4-5", text, signature)
+
+	data := Export()
+	ClearSignatureCache("")
+	if GetCachedSignature("claude-sonnet-4-5", text) != "" {
+		t.Fatal("Expected cache to be empty after clearing")
+	}
+
+	if err := Import(data); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if got := GetCachedSignature("claude-sonnet-4-5", text); got != signature {
+		t.Errorf("Expected signature restored after import, got %q", got)
+	}
+
+	if err := Import([]byte(`{"version":99,"entries":[]}`)); err == nil {
+		t.Error("Expected an error for an unsupported version")
+	}
+	if err := Import([]byte(`not json`)); err == nil {
+		t.Error("Expected an error for malformed data")
+	}
+}
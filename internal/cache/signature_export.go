@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// signatureExportVersion is the version of the Export format. Version 1 is the first format; the
+// signature cache is otherwise memory-only, so no older layout exists to stay compatible with.
+// Bump it whenever the layout changes, since Import rejects other versions.
+const signatureExportVersion = 1
+
+// signatureExport is the JSON document written by Export and read by Import: a version number
+// and the unexpired entries of every session.
+type signatureExport struct {
+	Version int                    `json:"version"`
+	Entries []signatureExportEntry `json:"entries"`
+}
+
+type signatureExportEntry struct {
+	Session   string    `json:"session"`
+	Key       string    `json:"key"`
+	Signature string    `json:"signature"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Export serializes all unexpired signature cache entries, e.g. to carry a warm cache over when
+// moving the proxy to another host. Entries are keyed by text hash, so no thinking text is included.
+func Export() []byte {
+	now := time.Now()
+	snapshot := signatureExport{Version: signatureExportVersion, Entries: []signatureExportEntry{}}
+	signatureCache.Range(func(key, value any) bool {
+		sessionID, _ := key.(string)
+		sc := value.(*sessionCache)
+		sc.mu.RLock()
+		for textHash, entry := range sc.entries {
+			if now.Sub(entry.Timestamp) > SignatureCacheTTL {
+				continue
+			}
+			snapshot.Entries = append(snapshot.Entries, signatureExportEntry{
+				Session:   sessionID,
+				Key:       textHash,
+				Signature: entry.Signature,
+				Timestamp: entry.Timestamp,
+			})
+		}
+		sc.mu.RUnlock()
+		return true
+	})
+	data, _ := json.Marshal(snapshot)
+	return data
+}
+
+// Import loads entries produced by Export into the signature cache. Expired entries and entries
+// with invalid signatures are skipped, and an existing entry is only replaced by a newer one.
+func Import(data []byte) error {
+	var snapshot signatureExport
+	if errUnmarshal := json.Unmarshal(data, &snapshot); errUnmarshal != nil {
+		return fmt.Errorf("signature cache import: %w", errUnmarshal)
+	}
+	if snapshot.Version != signatureExportVersion {
+		return fmt.Errorf("signature cache import: unsupported version %d", snapshot.Version)
+	}
+
+	now := time.Now()
+	for _, entry := range snapshot.Entries {
+		if entry.Session == "" || entry.Key == "" || len(entry.Signature) < MinValidSignatureLen {
+			continue
+		}
+		if now.Sub(entry.Timestamp) > SignatureCacheTTL {
+			continue
+		}
+		sc := getOrCreateSession(entry.Session)
+		sc.mu.Lock()
+		if existing, ok := sc.entries[entry.Key]; !ok || existing.Timestamp.Before(entry.Timestamp) {
+			sc.entries[entry.Key] = SignatureEntry{Signature: entry.Signature, Timestamp: entry.Timestamp}
+		}
+		sc.mu.Unlock()
+	}
+	return nil
+}
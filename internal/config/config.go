@@ -61,6 +61,11 @@ type Config struct {
 	RequestRetry int `yaml:"request-retry" json:"request-retry"`
 	// MaxRetryInterval defines the maximum wait time in seconds before retrying a cooled-down credential.
 	MaxRetryInterval int `yaml:"max-retry-interval" json:"max-retry-interval"`
+	// RetryBaseDelayMs enables exponential backoff between retries, starting at this many
+	// milliseconds and doubling per attempt. 0 only waits for credential cooldowns.
+	RetryBaseDelayMs int `yaml:"retry-base-delay-ms,omitempty" json:"retry-base-delay-ms,omitempty"`
+	// RetryMaxDelayMs caps a single backoff delay in milliseconds. 0 means no cap besides max-retry-interval.
+	RetryMaxDelayMs int `yaml:"retry-max-delay-ms,omitempty" json:"retry-max-delay-ms,omitempty"`
 
 	// QuotaExceeded defines the behavior when a quota is exceeded.
 	QuotaExceeded QuotaExceeded `yaml:"quota-exceeded" json:"quota-exceeded"`
@@ -117,6 +122,76 @@ type Config struct {
 	// Payload defines default and override rules for provider payload parameters.
 	Payload PayloadConfig `yaml:"payload" json:"payload"`
 
+	// MaxRequestBodyBytes rejects request bodies larger than this many bytes with 413 before any
+	// translation happens. <= 0 disables the limit. Default: 0.
+	MaxRequestBodyBytes int64 `yaml:"max-request-body-bytes,omitempty" json:"max-request-body-bytes,omitempty"`
+
+	// SafetyThresholds overrides the default Gemini safety threshold per harm category
+	// (e.g. HARM_CATEGORY_DANGEROUS_CONTENT: BLOCK_MEDIUM_AND_ABOVE). Categories not listed keep the default.
+	SafetyThresholds map[string]string `yaml:"safety-thresholds,omitempty" json:"safety-thresholds,omitempty"`
+
+	// MaxToolDeclarations caps the number of function declarations forwarded to Antigravity per request.
+	// Tools beyond the cap are dropped in client order with a warning. <= 0 disables the cap. Default: 0.
+	MaxToolDeclarations int `yaml:"max-tool-declarations,omitempty" json:"max-tool-declarations,omitempty"`
+
+	// MaxRequestParts caps the total number of content parts forwarded to Antigravity per Claude
+	// request. Oldest turns are dropped with a warning until the request fits; a single over-cap turn
+	// keeps its newest parts. <= 0 disables the cap.
+	MaxRequestParts int `yaml:"max-request-parts,omitempty" json:"max-request-parts,omitempty"`
+
+	// MaxThinkingParts caps how many of the most recent signed thinking parts are replayed to
+	// Antigravity per Claude request; older ones are dropped. <= 0 keeps them all. Default: 0.
+	MaxThinkingParts int `yaml:"max-thinking-parts,omitempty" json:"max-thinking-parts,omitempty"`
+
+	// EnforceTurnAlternation inserts minimal filler turns into Antigravity Claude requests so contents
+	// start with a user turn and strictly alternate user/model. Default: false.
+	EnforceTurnAlternation bool `yaml:"enforce-turn-alternation,omitempty" json:"enforce-turn-alternation,omitempty"`
+
+	// DefaultMaxOutputTokens is sent as maxOutputTokens for Antigravity Claude requests that omit
+	// max_tokens. <= 0 keeps the upstream default. Default: 0.
+	DefaultMaxOutputTokens int `yaml:"default-max-output-tokens,omitempty" json:"default-max-output-tokens,omitempty"`
+
+	// SanitizeTextParts strips invalid UTF-8 and control characters from Claude text sent to Antigravity.
+	SanitizeTextParts bool `yaml:"sanitize-text-parts,omitempty" json:"sanitize-text-parts,omitempty"`
+
+	// UnsignedThinking selects how Claude history thinking blocks without a valid signature are sent
+	// to Antigravity: "drop" (default) or "strip-to-text" (plain text part). Upstream rejects thought
+	// parts without a signature, so they cannot be kept as thoughts.
+	UnsignedThinking string `yaml:"unsigned-thinking,omitempty" json:"unsigned-thinking,omitempty"`
+
+	// InterleavedThinkingHint controls when the interleaved thinking hint is added to Antigravity Claude
+	// requests with thinking enabled: "tools" (only with tools, default), "always" or "never".
+	InterleavedThinkingHint string `yaml:"interleaved-thinking-hint,omitempty" json:"interleaved-thinking-hint,omitempty"`
+
+	// RoleLessSystemInstructionModels lists Antigravity models whose systemInstruction is sent without
+	// a role field. Entries ending in "*" match by prefix; other models keep the "user" role.
+	RoleLessSystemInstructionModels []string `yaml:"role-less-system-instruction-models,omitempty" json:"role-less-system-instruction-models,omitempty"`
+
+	// SystemInstructionOrder controls how OpenAI system and developer messages combine into the
+	// Antigravity systemInstruction: "messages" (request order, default), "system-first" or "developer-first".
+	SystemInstructionOrder string `yaml:"system-instruction-order,omitempty" json:"system-instruction-order,omitempty"`
+
+	// LogRequestFingerprint logs a SHA-256 digest of each Antigravity request body with its derived
+	// session ID and model, so reported requests can be matched without logging their content.
+	LogRequestFingerprint bool `yaml:"log-request-fingerprint,omitempty" json:"log-request-fingerprint,omitempty"`
+
+	// MetadataLabels forwards the Claude metadata user_id and session ID to Antigravity as
+	// Gemini request labels for billing and analytics.
+	MetadataLabels bool `yaml:"metadata-labels,omitempty" json:"metadata-labels,omitempty"`
+
+	// UpstreamModelIdentity, when set, replaces the model field of every Antigravity request with this
+	// value. The requested model still drives thinking and schema handling.
+	UpstreamModelIdentity string `yaml:"upstream-model-identity,omitempty" json:"upstream-model-identity,omitempty"`
+
+	// IncludeThoughts overrides thinkingConfig.includeThoughts for Gemini-family upstreams regardless
+	// of the thinking budget: "always", "never", or empty to derive it from the budget (default).
+	IncludeThoughts string `yaml:"include-thoughts,omitempty" json:"include-thoughts,omitempty"`
+
+	// SamplingLimits clamps temperature and top_p per model before requests reach Gemini-family
+	// upstreams, which reject out-of-range values with 400, and can pair top_p with a default
+	// top_k. The first matching entry applies.
+	SamplingLimits []SamplingLimit `yaml:"sampling-limits,omitempty" json:"sampling-limits,omitempty"`
+
 	// IncognitoBrowser enables opening OAuth URLs in incognito/private browsing mode.
 	// This is useful when you want to login with a different account without logging out
 	// from your current session. Default: false.
@@ -261,6 +336,19 @@ type PayloadModelRule struct {
 	Protocol string `yaml:"protocol" json:"protocol"`
 }
 
+// SamplingLimit bounds the sampling parameters forwarded for models matching Name.
+type SamplingLimit struct {
+	// Name is the model name or wildcard pattern (e.g., "gemini-2.5-*").
+	Name string `yaml:"name" json:"name"`
+	// MaxTemperature is the highest temperature forwarded. <= 0 leaves temperature unclamped.
+	MaxTemperature float64 `yaml:"max-temperature,omitempty" json:"max-temperature,omitempty"`
+	// MaxTopP is the highest top_p forwarded. <= 0 leaves top_p unclamped.
+	MaxTopP float64 `yaml:"max-top-p,omitempty" json:"max-top-p,omitempty"`
+	// DefaultTopK is forwarded as top_k when a request sets top_p without top_k, for models that
+	// misbehave unless both are present. <= 0 leaves top_k unset.
+	DefaultTopK int `yaml:"default-top-k,omitempty" json:"default-top-k,omitempty"`
+}
+
 // CloakConfig configures request cloaking for non-Claude-Code clients.
 // Cloaking disguises API requests to appear as originating from the official Claude Code CLI.
 type CloakConfig struct {
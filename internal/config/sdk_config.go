@@ -29,6 +29,10 @@ type SDKConfig struct {
 	// NonStreamKeepAliveInterval controls how often blank lines are emitted for non-streaming responses.
 	// <= 0 disables keep-alives. Value is in seconds.
 	NonStreamKeepAliveInterval int `yaml:"nonstream-keepalive-interval,omitempty" json:"nonstream-keepalive-interval,omitempty"`
+
+	// HTTPClientTimeout bounds each outbound request made by clients built with util.NewHTTPClient.
+	// <= 0 disables the client-level timeout. Value is in seconds.
+	HTTPClientTimeout int `yaml:"http-client-timeout,omitempty" json:"http-client-timeout,omitempty"`
 }
 
 // StreamingConfig holds server streaming behavior configuration.
@@ -9,6 +9,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,10 +22,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/thinking"
+	antigravityclaude "github.com/router-for-me/CLIProxyAPI/v6/internal/translator/antigravity/claude"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	sdkAuth "github.com/router-for-me/CLIProxyAPI/v6/sdk/auth"
 	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
@@ -134,15 +137,28 @@ func (e *AntigravityExecutor) Execute(ctx context.Context, auth *cliproxyauth.Au
 	if len(opts.OriginalRequest) > 0 {
 		originalPayload = bytes.Clone(opts.OriginalRequest)
 	}
-	originalTranslated := sdktranslator.TranslateRequest(from, to, baseModel, originalPayload, false)
-	translated := sdktranslator.TranslateRequest(from, to, baseModel, bytes.Clone(req.Payload), false)
+	e.logRequestFingerprint(baseModel, originalPayload)
+	originalTranslated, _, err := translateAntigravityRequest(ctx, opts.Headers, from, to, baseModel, originalPayload, false)
+	if err != nil {
+		return resp, err
+	}
+	translated, warnings, err := translateAntigravityRequest(ctx, opts.Headers, from, to, baseModel, bytes.Clone(req.Payload), false)
+	if err != nil {
+		return resp, err
+	}
+	reportConversionWarnings(ctx, warnings)
 
 	translated, err = thinking.ApplyThinking(translated, req.Model, from.String(), to.String(), e.Identifier())
 	if err != nil {
 		return resp, err
 	}
+	translated = enforceClaudeThinkingDisabled(from, req.Payload, translated)
 
 	translated = applyPayloadConfigWithRoot(e.cfg, baseModel, "antigravity", "request", translated, originalTranslated)
+	translated = applySamplingLimits(e.cfg, baseModel, "request", translated)
+	translated = applyIncludeThoughts(e.cfg, "request", translated)
+	// Stats describe the final body, including thinking applied from a model suffix.
+	logConversionStats(from, req.Payload, translated)
 
 	baseURLs := antigravityBaseURLFallbackOrder(auth)
 	httpClient := newProxyAwareHTTPClient(ctx, e.cfg, auth, 0)
@@ -253,15 +269,28 @@ func (e *AntigravityExecutor) executeClaudeNonStream(ctx context.Context, auth *
 	if len(opts.OriginalRequest) > 0 {
 		originalPayload = bytes.Clone(opts.OriginalRequest)
 	}
-	originalTranslated := sdktranslator.TranslateRequest(from, to, baseModel, originalPayload, true)
-	translated := sdktranslator.TranslateRequest(from, to, baseModel, bytes.Clone(req.Payload), true)
+	e.logRequestFingerprint(baseModel, originalPayload)
+	originalTranslated, _, err := translateAntigravityRequest(ctx, opts.Headers, from, to, baseModel, originalPayload, true)
+	if err != nil {
+		return resp, err
+	}
+	translated, warnings, err := translateAntigravityRequest(ctx, opts.Headers, from, to, baseModel, bytes.Clone(req.Payload), true)
+	if err != nil {
+		return resp, err
+	}
+	reportConversionWarnings(ctx, warnings)
 
 	translated, err = thinking.ApplyThinking(translated, req.Model, from.String(), to.String(), e.Identifier())
 	if err != nil {
 		return resp, err
 	}
+	translated = enforceClaudeThinkingDisabled(from, req.Payload, translated)
 
 	translated = applyPayloadConfigWithRoot(e.cfg, baseModel, "antigravity", "request", translated, originalTranslated)
+	translated = applySamplingLimits(e.cfg, baseModel, "request", translated)
+	translated = applyIncludeThoughts(e.cfg, "request", translated)
+	// Stats describe the final body, including thinking applied from a model suffix.
+	logConversionStats(from, req.Payload, translated)
 
 	baseURLs := antigravityBaseURLFallbackOrder(auth)
 	httpClient := newProxyAwareHTTPClient(ctx, e.cfg, auth, 0)
@@ -619,15 +648,28 @@ func (e *AntigravityExecutor) ExecuteStream(ctx context.Context, auth *cliproxya
 	if len(opts.OriginalRequest) > 0 {
 		originalPayload = bytes.Clone(opts.OriginalRequest)
 	}
-	originalTranslated := sdktranslator.TranslateRequest(from, to, baseModel, originalPayload, true)
-	translated := sdktranslator.TranslateRequest(from, to, baseModel, bytes.Clone(req.Payload), true)
+	e.logRequestFingerprint(baseModel, originalPayload)
+	originalTranslated, _, err := translateAntigravityRequest(ctx, opts.Headers, from, to, baseModel, originalPayload, true)
+	if err != nil {
+		return nil, err
+	}
+	translated, warnings, err := translateAntigravityRequest(ctx, opts.Headers, from, to, baseModel, bytes.Clone(req.Payload), true)
+	if err != nil {
+		return nil, err
+	}
+	reportConversionWarnings(ctx, warnings)
 
 	translated, err = thinking.ApplyThinking(translated, req.Model, from.String(), to.String(), e.Identifier())
 	if err != nil {
 		return nil, err
 	}
+	translated = enforceClaudeThinkingDisabled(from, req.Payload, translated)
 
 	translated = applyPayloadConfigWithRoot(e.cfg, baseModel, "antigravity", "request", translated, originalTranslated)
+	translated = applySamplingLimits(e.cfg, baseModel, "request", translated)
+	translated = applyIncludeThoughts(e.cfg, "request", translated)
+	// Stats describe the final body, including thinking applied from a model suffix.
+	logConversionStats(from, req.Payload, translated)
 
 	baseURLs := antigravityBaseURLFallbackOrder(auth)
 	httpClient := newProxyAwareHTTPClient(ctx, e.cfg, auth, 0)
@@ -768,6 +810,90 @@ func (e *AntigravityExecutor) ExecuteStream(ctx context.Context, auth *cliproxya
 	return nil, err
 }
 
+// logRequestFingerprint logs a SHA-256 digest of the inbound body with the derived session ID and
+// model when log-request-fingerprint is enabled, so a reported request can be matched against a
+// user's sample without logging its content.
+func (e *AntigravityExecutor) logRequestFingerprint(model string, payload []byte) {
+	if e.cfg == nil || !e.cfg.LogRequestFingerprint {
+		return
+	}
+	log.Infof("antigravity request fingerprint: sha256=%s session=%s model=%s", requestFingerprint(payload), antigravityclaude.DeriveSessionID(payload), model)
+}
+
+func requestFingerprint(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// translateAntigravityRequest translates the payload for Antigravity. Claude requests go through
+// the header-aware converter so anthropic-beta flags, which the translator registry never sees,
+// can influence the translation. Explicit option headers win over the inbound gin request headers.
+// The conversion warnings of Claude requests are returned for reportConversionWarnings, and a Claude
+// body that is not a JSON object is rejected with a 400 status error.
+func translateAntigravityRequest(ctx context.Context, headers http.Header, from, to sdktranslator.Format, model string, payload []byte, stream bool) ([]byte, []antigravityclaude.Warning, error) {
+	if from.String() == "claude" {
+		if headers == nil {
+			if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil && ginCtx.Request != nil {
+				headers = ginCtx.Request.Header
+			}
+		}
+		opts := antigravityclaude.RequestOptions{AnthropicBetas: antigravityclaude.AnthropicBetasFromHeaders(headers)}
+		out, warnings, err := antigravityclaude.ConvertClaudeRequestToAntigravityE(model, payload, stream, opts)
+		if err != nil {
+			return nil, nil, statusErr{code: http.StatusBadRequest, msg: err.Error()}
+		}
+		return out, warnings, nil
+	}
+	return sdktranslator.TranslateRequest(from, to, model, payload, stream), nil, nil
+}
+
+// enforceClaudeThinkingDisabled strips any thinkingConfig from payload when the Claude request
+// explicitly disabled thinking. It runs after thinking.ApplyThinking, which would otherwise
+// re-add a thinking config derived from a model suffix.
+func enforceClaudeThinkingDisabled(from sdktranslator.Format, claudeRequest, payload []byte) []byte {
+	if from.String() != "claude" || gjson.GetBytes(claudeRequest, "thinking.type").String() != "disabled" {
+		return payload
+	}
+	return thinking.StripThinkingConfig(payload, "antigravity")
+}
+
+// logConversionStats logs the cost-tracking statistics of a Claude conversion at debug level.
+func logConversionStats(from sdktranslator.Format, claudeRequest, translated []byte) {
+	if from.String() != "claude" || !log.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+	stats := antigravityclaude.ConversionStatsFor(claudeRequest, translated)
+	log.Debugf("antigravity conversion stats: estimated_input_tokens=%d tool_declarations=%d thinking=%t system_cached_parts=%d", stats.EstimatedInputTokens, stats.ToolDeclarations, stats.ThinkingEnabled, stats.SystemCachedParts)
+}
+
+// reportConversionWarnings surfaces the codes of conversion warnings in the response header.
+// Only the warnings of the payload actually sent upstream are reported.
+func reportConversionWarnings(ctx context.Context, warnings []antigravityclaude.Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+	if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil {
+		ginCtx.Header(conversionWarningsHeader, conversionWarningCodes(warnings))
+	}
+}
+
+// conversionWarningsHeader carries the codes of conversion warnings back to the client for debugging.
+const conversionWarningsHeader = "X-CPA-Conversion-Warnings"
+
+// conversionWarningCodes joins the distinct warning codes in first-seen order.
+func conversionWarningCodes(warnings []antigravityclaude.Warning) string {
+	codes := make([]string, 0, len(warnings))
+	seen := make(map[string]struct{}, len(warnings))
+	for _, warning := range warnings {
+		if _, ok := seen[warning.Code]; ok {
+			continue
+		}
+		seen[warning.Code] = struct{}{}
+		codes = append(codes, warning.Code)
+	}
+	return strings.Join(codes, ",")
+}
+
 // Refresh refreshes the authentication credentials using the refresh token.
 func (e *AntigravityExecutor) Refresh(ctx context.Context, auth *cliproxyauth.Auth) (*cliproxyauth.Auth, error) {
 	if auth == nil {
@@ -800,12 +926,16 @@ func (e *AntigravityExecutor) CountTokens(ctx context.Context, auth *cliproxyaut
 	respCtx := context.WithValue(ctx, "alt", opts.Alt)
 
 	// Prepare payload once (doesn't depend on baseURL)
-	payload := sdktranslator.TranslateRequest(from, to, baseModel, bytes.Clone(req.Payload), false)
+	payload, _, err := translateAntigravityRequest(ctx, opts.Headers, from, to, baseModel, bytes.Clone(req.Payload), false)
+	if err != nil {
+		return cliproxyexecutor.Response{}, err
+	}
 
-	payload, err := thinking.ApplyThinking(payload, req.Model, from.String(), to.String(), e.Identifier())
+	payload, err = thinking.ApplyThinking(payload, req.Model, from.String(), to.String(), e.Identifier())
 	if err != nil {
 		return cliproxyexecutor.Response{}, err
 	}
+	payload = enforceClaudeThinkingDisabled(from, req.Payload, payload)
 
 	payload = deleteJSONField(payload, "project")
 	payload = deleteJSONField(payload, "model")
@@ -1200,7 +1330,7 @@ func (e *AntigravityExecutor) buildRequest(ctx context.Context, auth *cliproxyau
 		}
 	}
 	payload = geminiToAntigravity(modelName, payload, projectID)
-	payload, _ = sjson.SetBytes(payload, "model", modelName)
+	payload, _ = sjson.SetBytes(payload, "model", e.upstreamModel(modelName))
 
 	if strings.Contains(modelName, "claude") || strings.Contains(modelName, "gemini-3-pro-high") {
 		strJSON := string(payload)
@@ -1267,6 +1397,17 @@ func (e *AntigravityExecutor) buildRequest(ctx context.Context, auth *cliproxyau
 	return httpReq, nil
 }
 
+// upstreamModel returns the model name sent upstream: the configured identity when set,
+// modelName otherwise.
+func (e *AntigravityExecutor) upstreamModel(modelName string) string {
+	if e.cfg != nil {
+		if identity := strings.TrimSpace(e.cfg.UpstreamModelIdentity); identity != "" {
+			return identity
+		}
+	}
+	return modelName
+}
+
 func tokenExpiry(metadata map[string]any) time.Time {
 	if metadata == nil {
 		return time.Time{}
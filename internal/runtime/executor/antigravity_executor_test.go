@@ -0,0 +1,214 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/thinking"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	sdktranslator "github.com/router-for-me/CLIProxyAPI/v6/sdk/translator"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/tidwall/gjson"
+)
+
+func TestAntigravityLogRequestFingerprint(t *testing.T) {
+	hook := test.NewLocal(log.StandardLogger())
+	defer hook.Reset()
+
+	payload := []byte(`{"model":"claude-sonnet-4-5","metadata":{"user_id":"user_abc_session_42"},"messages":[{"role":"user","content":"Hi"}]}`)
+
+	disabled := NewAntigravityExecutor(&config.Config{})
+	disabled.logRequestFingerprint("claude-sonnet-4-5", payload)
+	if len(hook.AllEntries()) != 0 {
+		t.Fatal("Expected no fingerprint log unless enabled")
+	}
+
+	enabled := NewAntigravityExecutor(&config.Config{LogRequestFingerprint: true})
+	enabled.logRequestFingerprint("claude-sonnet-4-5", payload)
+	enabled.logRequestFingerprint("claude-sonnet-4-5", append([]byte(nil), payload...))
+
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 fingerprint log entries, got %d", len(entries))
+	}
+	if entries[0].Message != entries[1].Message {
+		t.Errorf("Expected identical inputs to log the same fingerprint, got %q and %q", entries[0].Message, entries[1].Message)
+	}
+	msg := entries[0].Message
+	if !strings.Contains(msg, "sha256="+requestFingerprint(payload)) || !strings.Contains(msg, "session=42") || !strings.Contains(msg, "model=claude-sonnet-4-5") {
+		t.Errorf("Unexpected fingerprint log: %q", msg)
+	}
+	if strings.Contains(msg, "Hi") {
+		t.Errorf("Fingerprint log must not contain the request body: %q", msg)
+	}
+	if requestFingerprint(payload) == requestFingerprint([]byte(`{}`)) {
+		t.Error("Expected different inputs to produce different fingerprints")
+	}
+}
+
+func TestLogConversionStats(t *testing.T) {
+	hook := test.NewLocal(log.StandardLogger())
+	defer hook.Reset()
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+
+	from := sdktranslator.FromString("claude")
+	request := []byte(`{"system":[{"type":"text","text":"Cached","cache_control":{"type":"ephemeral"}},{"type":"text","text":"Fresh"}],"messages":[{"role":"user","content":"Hi"}],"tools":[{"name":"get_weather","input_schema":{"type":"object"}}]}`)
+	translated, _, err := translateAntigravityRequest(context.Background(), nil, from, sdktranslator.FromString("antigravity"), "claude-sonnet-4-5", request, false)
+	if err != nil {
+		t.Fatalf("translateAntigravityRequest: %v", err)
+	}
+
+	log.SetLevel(log.InfoLevel)
+	logConversionStats(from, request, translated)
+	if len(hook.AllEntries()) != 0 {
+		t.Fatal("Expected no stats log above debug level")
+	}
+
+	log.SetLevel(log.DebugLevel)
+	logConversionStats(from, request, translated)
+	entry := hook.LastEntry()
+	if entry == nil || !strings.Contains(entry.Message, "tool_declarations=1") || !strings.Contains(entry.Message, "thinking=false") || !strings.Contains(entry.Message, "system_cached_parts=1") {
+		t.Fatalf("Unexpected stats log: %v", entry)
+	}
+}
+
+func TestTranslateAntigravityRequestSurfacesConversionWarnings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ginCtx, _ := gin.CreateTestContext(recorder)
+	ginCtx.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+	ctx := context.WithValue(context.Background(), "gin", ginCtx)
+
+	payload := []byte(`{"messages":[{"role":"user","content":[{"type":"text","text":"Hi"},{"type":"document"},{"type":"container_upload"}]}]}`)
+	translated, warnings, err := translateAntigravityRequest(ctx, nil, sdktranslator.FromString("claude"), sdktranslator.FromString("antigravity"), "claude-sonnet-4-5", payload, false)
+	if err != nil {
+		t.Fatalf("translateAntigravityRequest: %v", err)
+	}
+	if !strings.Contains(string(translated), `"Hi"`) {
+		t.Fatalf("Expected translated request, got %s", translated)
+	}
+	if got := recorder.Header().Get(conversionWarningsHeader); got != "" {
+		t.Fatalf("Expected translation alone to leave the header unset, got %q", got)
+	}
+	reportConversionWarnings(ctx, warnings)
+	if got := recorder.Header().Get(conversionWarningsHeader); got != "dropped_unknown_block" {
+		t.Errorf("Expected deduplicated warning codes in header, got %q", got)
+	}
+}
+
+func TestResetClientCachesClearsHTTPClientCache(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.ProxyURL = "http://127.0.0.1:3128"
+	_ = newProxyAwareHTTPClient(context.Background(), cfg, nil, 0)
+
+	httpClientCacheMutex.RLock()
+	cached := len(httpClientCache)
+	httpClientCacheMutex.RUnlock()
+	if cached == 0 {
+		t.Fatal("Expected a cached HTTP client before reset")
+	}
+
+	util.ResetClientCaches()
+
+	httpClientCacheMutex.RLock()
+	defer httpClientCacheMutex.RUnlock()
+	if len(httpClientCache) != 0 {
+		t.Errorf("Expected HTTP client cache cleared, got %d entries", len(httpClientCache))
+	}
+}
+
+func TestAntigravityBuildRequest_UpstreamModelIdentity(t *testing.T) {
+	payload := []byte(`{"request":{"contents":[{"role":"user","parts":[{"text":"Hi"}]}],"tools":[{"functionDeclarations":[{"name":"f","parametersJsonSchema":{"type":"object"}}]}]}}`)
+
+	exec := NewAntigravityExecutor(&config.Config{UpstreamModelIdentity: "audit-model"})
+	req, err := exec.buildRequest(context.Background(), nil, "token", "claude-sonnet-4-5-thinking", payload, false, "", "http://127.0.0.1")
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if got := gjson.GetBytes(body, "model").String(); got != "audit-model" {
+		t.Errorf("Expected model overridden to audit-model, got %q", got)
+	}
+	// Claude-specific handling still keys off the requested model.
+	if !gjson.GetBytes(body, "request.tools.0.functionDeclarations.0.parameters").Exists() {
+		t.Errorf("Expected Claude schema handling for the requested model, got %s", body)
+	}
+
+	exec = NewAntigravityExecutor(&config.Config{})
+	req, err = exec.buildRequest(context.Background(), nil, "token", "claude-sonnet-4-5-thinking", payload, false, "", "http://127.0.0.1")
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	body, _ = io.ReadAll(req.Body)
+	if got := gjson.GetBytes(body, "model").String(); got != "claude-sonnet-4-5-thinking" {
+		t.Errorf("Expected requested model without override, got %q", got)
+	}
+}
+
+func TestTranslateAntigravityRequestRejectsMalformedClaudeJSON(t *testing.T) {
+	from := sdktranslator.FromString("claude")
+	to := sdktranslator.FromString("antigravity")
+
+	_, _, err := translateAntigravityRequest(context.Background(), nil, from, to, "claude-sonnet-4-5", []byte(`{"messages": [`), false)
+	if err == nil {
+		t.Fatal("Expected an error for malformed JSON")
+	}
+	var status statusErr
+	if !errors.As(err, &status) || status.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("Expected a 400 status error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid claude request JSON") {
+		t.Errorf("Expected the parse detail in the error, got %v", err)
+	}
+}
+
+func TestTranslateAntigravityRequestRejectsUnsupportedImage(t *testing.T) {
+	payload := []byte(`{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/bmp","data":"Qk0="}}]}]}`)
+
+	_, _, err := translateAntigravityRequest(context.Background(), nil, sdktranslator.FromString("claude"), sdktranslator.FromString("antigravity"), "claude-sonnet-4-5", payload, false)
+	var status statusErr
+	if !errors.As(err, &status) || status.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("Expected a 400 status error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "image/bmp") {
+		t.Errorf("Expected the error to name the media type, got %v", err)
+	}
+}
+
+func TestEnforceClaudeThinkingDisabledAfterApplyThinking(t *testing.T) {
+	from := sdktranslator.FromString("claude")
+	to := sdktranslator.FromString("antigravity")
+	request := []byte(`{"model":"claude-opus-4-5-thinking","thinking":{"type":"disabled"},"messages":[{"role":"user","content":"Hi"}]}`)
+	model := "claude-opus-4-5-thinking(8192)"
+
+	translated, _, err := translateAntigravityRequest(context.Background(), nil, from, to, "claude-opus-4-5-thinking", request, false)
+	if err != nil {
+		t.Fatalf("translateAntigravityRequest: %v", err)
+	}
+	applied, err := thinking.ApplyThinking(translated, model, from.String(), to.String(), "antigravity")
+	if err != nil {
+		t.Fatalf("ApplyThinking: %v", err)
+	}
+	if !gjson.GetBytes(applied, "request.generationConfig.thinkingConfig").Exists() {
+		t.Fatalf("Expected the model suffix to add a thinkingConfig, got %s", applied)
+	}
+
+	out := enforceClaudeThinkingDisabled(from, request, applied)
+	if gjson.GetBytes(out, "request.generationConfig.thinkingConfig").Exists() {
+		t.Errorf("Expected disabled thinking to strip thinkingConfig, got %s", out)
+	}
+
+	enabled := []byte(`{"thinking":{"type":"enabled","budget_tokens":1024}}`)
+	if out := enforceClaudeThinkingDisabled(from, enabled, applied); !gjson.GetBytes(out, "request.generationConfig.thinkingConfig").Exists() {
+		t.Error("Expected enabled thinking to keep thinkingConfig")
+	}
+}
@@ -130,6 +130,8 @@ func (e *GeminiCLIExecutor) Execute(ctx context.Context, auth *cliproxyauth.Auth
 
 	basePayload = fixGeminiCLIImageAspectRatio(baseModel, basePayload)
 	basePayload = applyPayloadConfigWithRoot(e.cfg, baseModel, "gemini", "request", basePayload, originalTranslated)
+	basePayload = applySamplingLimits(e.cfg, baseModel, "request", basePayload)
+	basePayload = applyIncludeThoughts(e.cfg, "request", basePayload)
 
 	action := "generateContent"
 	if req.Metadata != nil {
@@ -279,6 +281,8 @@ func (e *GeminiCLIExecutor) ExecuteStream(ctx context.Context, auth *cliproxyaut
 
 	basePayload = fixGeminiCLIImageAspectRatio(baseModel, basePayload)
 	basePayload = applyPayloadConfigWithRoot(e.cfg, baseModel, "gemini", "request", basePayload, originalTranslated)
+	basePayload = applySamplingLimits(e.cfg, baseModel, "request", basePayload)
+	basePayload = applyIncludeThoughts(e.cfg, "request", basePayload)
 
 	projectID := resolveGeminiProjectID(auth)
 
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
@@ -258,6 +259,78 @@ func payloadRawValue(value any) ([]byte, bool) {
 	}
 }
 
+// applySamplingLimits clamps generationConfig.temperature and topP under root to the first
+// configured sampling limit matching model, logging each adjustment. When that limit has a
+// DefaultTopK, it is also set if topP is present without topK.
+func applySamplingLimits(cfg *config.Config, model, root string, payload []byte) []byte {
+	if cfg == nil || len(cfg.SamplingLimits) == 0 || len(payload) == 0 {
+		return payload
+	}
+	for i := range cfg.SamplingLimits {
+		limit := &cfg.SamplingLimits[i]
+		if !matchModelPattern(limit.Name, model) {
+			continue
+		}
+		payload = clampSamplingParam(payload, buildPayloadPath(root, "generationConfig.temperature"), limit.MaxTemperature, model)
+		payload = clampSamplingParam(payload, buildPayloadPath(root, "generationConfig.topP"), limit.MaxTopP, model)
+		topKPath := buildPayloadPath(root, "generationConfig.topK")
+		if limit.DefaultTopK > 0 && gjson.GetBytes(payload, buildPayloadPath(root, "generationConfig.topP")).Exists() && !gjson.GetBytes(payload, topKPath).Exists() {
+			if updated, errSet := sjson.SetBytes(payload, topKPath, limit.DefaultTopK); errSet == nil {
+				log.Debugf("set %s to %d for model %s because only topP was provided", topKPath, limit.DefaultTopK, model)
+				payload = updated
+			}
+		}
+		return payload
+	}
+	return payload
+}
+
+// Include-thoughts modes for Config.IncludeThoughts.
+const (
+	includeThoughtsAlways = "always"
+	includeThoughtsNever  = "never"
+)
+
+// applyIncludeThoughts overrides generationConfig.thinkingConfig.includeThoughts under root with
+// the configured include-thoughts mode, independent of the thinking budget. It only touches an
+// existing thinkingConfig, and "always" leaves a disabled (zero) budget alone since no thoughts
+// are produced for it.
+func applyIncludeThoughts(cfg *config.Config, root string, payload []byte) []byte {
+	if cfg == nil || len(payload) == 0 {
+		return payload
+	}
+	mode := strings.ToLower(strings.TrimSpace(cfg.IncludeThoughts))
+	if mode != includeThoughtsAlways && mode != includeThoughtsNever {
+		return payload
+	}
+	thinkingConfig := gjson.GetBytes(payload, buildPayloadPath(root, "generationConfig.thinkingConfig"))
+	if !thinkingConfig.IsObject() {
+		return payload
+	}
+	include := mode == includeThoughtsAlways
+	if budget := thinkingConfig.Get("thinkingBudget"); include && budget.Exists() && budget.Int() == 0 {
+		return payload
+	}
+	updated, errSet := sjson.SetBytes(payload, buildPayloadPath(root, "generationConfig.thinkingConfig.includeThoughts"), include)
+	if errSet != nil {
+		return payload
+	}
+	return updated
+}
+
+func clampSamplingParam(payload []byte, path string, maxValue float64, model string) []byte {
+	value := gjson.GetBytes(payload, path)
+	if maxValue <= 0 || value.Type != gjson.Number || value.Float() <= maxValue {
+		return payload
+	}
+	updated, errSet := sjson.SetBytes(payload, path, maxValue)
+	if errSet != nil {
+		return payload
+	}
+	log.Infof("clamped %s from %v to %v for model %s", path, value.Float(), maxValue, model)
+	return updated
+}
+
 // matchModelPattern performs simple wildcard matching where '*' matches zero or more characters.
 // Examples:
 //
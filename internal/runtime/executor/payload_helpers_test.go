@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/tidwall/gjson"
+)
+
+func TestApplySamplingLimits(t *testing.T) {
+	cfg := &config.Config{SamplingLimits: []config.SamplingLimit{
+		{Name: "gemini-2.5-*", MaxTemperature: 1.5, MaxTopP: 0.95},
+		{Name: "*", MaxTemperature: 2},
+	}}
+	payload := []byte(`{"request":{"generationConfig":{"temperature":1.9,"topP":1,"topK":40}}}`)
+
+	out := applySamplingLimits(cfg, "gemini-2.5-pro", "request", payload)
+	if got := gjson.GetBytes(out, "request.generationConfig.temperature").Float(); got != 1.5 {
+		t.Errorf("Expected temperature clamped to 1.5, got %v", got)
+	}
+	if got := gjson.GetBytes(out, "request.generationConfig.topP").Float(); got != 0.95 {
+		t.Errorf("Expected topP clamped to 0.95, got %v", got)
+	}
+	if got := gjson.GetBytes(out, "request.generationConfig.topK").Int(); got != 40 {
+		t.Errorf("Expected other fields untouched, got topK %d", got)
+	}
+
+	// Only the first matching entry applies; it leaves top_p unclamped.
+	out = applySamplingLimits(cfg, "claude-sonnet-4-5", "request", payload)
+	if got := gjson.GetBytes(out, "request.generationConfig.temperature").Float(); got != 1.9 {
+		t.Errorf("Expected in-range temperature kept, got %v", got)
+	}
+	if got := gjson.GetBytes(out, "request.generationConfig.topP").Float(); got != 1 {
+		t.Errorf("Expected topP kept without a cap, got %v", got)
+	}
+
+	rootless := applySamplingLimits(cfg, "gemini-2.5-flash", "", []byte(`{"generationConfig":{"temperature":3}}`))
+	if got := gjson.GetBytes(rootless, "generationConfig.temperature").Float(); got != 1.5 {
+		t.Errorf("Expected root-level generationConfig clamped, got %v", got)
+	}
+}
+
+func TestApplySamplingLimits_DefaultTopK(t *testing.T) {
+	cfg := &config.Config{SamplingLimits: []config.SamplingLimit{
+		{Name: "gemini-2.5-*", DefaultTopK: 64},
+		{Name: "*", MaxTemperature: 2},
+	}}
+	topPOnly := []byte(`{"request":{"generationConfig":{"topP":0.9}}}`)
+
+	out := applySamplingLimits(cfg, "gemini-2.5-pro", "request", topPOnly)
+	if got := gjson.GetBytes(out, "request.generationConfig.topK").Int(); got != 64 {
+		t.Errorf("Expected default topK 64 for the affected family, got %s", out)
+	}
+
+	out = applySamplingLimits(cfg, "gemini-3-pro-preview", "request", topPOnly)
+	if gjson.GetBytes(out, "request.generationConfig.topK").Exists() {
+		t.Errorf("Expected no topK for other models, got %s", out)
+	}
+
+	withTopK := []byte(`{"request":{"generationConfig":{"topP":0.9,"topK":20}}}`)
+	out = applySamplingLimits(cfg, "gemini-2.5-pro", "request", withTopK)
+	if got := gjson.GetBytes(out, "request.generationConfig.topK").Int(); got != 20 {
+		t.Errorf("Expected client topK kept, got %d", got)
+	}
+
+	out = applySamplingLimits(cfg, "gemini-2.5-pro", "request", []byte(`{"request":{"generationConfig":{"temperature":1}}}`))
+	if gjson.GetBytes(out, "request.generationConfig.topK").Exists() {
+		t.Errorf("Expected no topK without topP, got %s", out)
+	}
+}
+
+func TestApplyIncludeThoughts(t *testing.T) {
+	positive := []byte(`{"request":{"generationConfig":{"thinkingConfig":{"thinkingBudget":1024,"includeThoughts":true}}}}`)
+	zero := []byte(`{"request":{"generationConfig":{"thinkingConfig":{"thinkingBudget":0,"includeThoughts":false}}}}`)
+	noThinking := []byte(`{"request":{"generationConfig":{"temperature":1}}}`)
+
+	tests := []struct {
+		mode    string
+		payload []byte
+		want    string // expected includeThoughts raw value, "" when absent
+	}{
+		{mode: "", payload: positive, want: "true"},
+		{mode: "never", payload: positive, want: "false"},
+		{mode: "always", payload: positive, want: "true"},
+		{mode: "always", payload: zero, want: "false"},
+		{mode: "never", payload: zero, want: "false"},
+		{mode: "always", payload: noThinking, want: ""},
+		{mode: "bogus", payload: positive, want: "true"},
+	}
+	for _, tt := range tests {
+		out := applyIncludeThoughts(&config.Config{IncludeThoughts: tt.mode}, "request", tt.payload)
+		if got := gjson.GetBytes(out, "request.generationConfig.thinkingConfig.includeThoughts").Raw; got != tt.want {
+			t.Errorf("mode %q on %s: expected includeThoughts %q, got %q", tt.mode, tt.payload, tt.want, got)
+		}
+	}
+
+	levelOnly := []byte(`{"generationConfig":{"thinkingConfig":{"thinkingLevel":"high"}}}`)
+	out := applyIncludeThoughts(&config.Config{IncludeThoughts: "always"}, "", levelOnly)
+	if !gjson.GetBytes(out, "generationConfig.thinkingConfig.includeThoughts").Bool() {
+		t.Errorf("Expected root-level thinkingConfig to include thoughts, got %s", out)
+	}
+}
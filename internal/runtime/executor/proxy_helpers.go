@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/proxy"
@@ -21,12 +22,25 @@ var (
 	httpClientCacheMutex sync.RWMutex
 )
 
+func init() {
+	util.RegisterCacheReset(clearHTTPClientCache)
+}
+
+// clearHTTPClientCache drops every cached client so the next request builds a fresh transport.
+func clearHTTPClientCache() {
+	httpClientCacheMutex.Lock()
+	clear(httpClientCache)
+	httpClientCacheMutex.Unlock()
+}
+
 // newProxyAwareHTTPClient creates an HTTP client with proper proxy configuration priority:
 // 1. Use auth.ProxyURL if configured (highest priority)
 // 2. Use cfg.ProxyURL if auth proxy is not configured
 // 3. Use RoundTripper from context if neither are configured
 //
 // This function caches HTTP clients by proxy URL to enable TCP/TLS connection reuse.
+// Per-credential proxies are applied here through auth.ProxyURL; a util.WithProxyURL override on
+// the request context, set by SDK callers, still wins over both configured proxies.
 //
 // Parameters:
 //   - ctx: The context containing optional RoundTripper
@@ -90,6 +104,9 @@ func newProxyAwareHTTPClient(ctx context.Context, cfg *config.Config, auth *clip
 	// Priority 3: Use RoundTripper from context (typically from RoundTripperFor)
 	if rt, ok := ctx.Value("cliproxy.roundtripper").(http.RoundTripper); ok && rt != nil {
 		httpClient.Transport = rt
+	} else {
+		// The shared direct transport honours the proxy environment and util.WithProxyURL.
+		httpClient.Transport = util.DirectTransport()
 	}
 
 	// Cache the client for no-proxy case
@@ -140,6 +157,10 @@ func buildProxyTransport(proxyURL string) *http.Transport {
 		// Set up a custom transport using the SOCKS5 dialer
 		transport = &http.Transport{
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				// Dial with the request context so a cancelled request also aborts the SOCKS5 handshake.
+				if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+					return contextDialer.DialContext(ctx, network, addr)
+				}
 				return dialer.Dial(network, addr)
 			},
 		}
@@ -151,5 +172,6 @@ func buildProxyTransport(proxyURL string) *http.Transport {
 		return nil
 	}
 
-	return transport
+	// Requests carrying a util.WithProxyURL override bypass the configured proxy.
+	return util.HonorProxyOverride(transport)
 }
@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+func TestNewProxyAwareHTTPClientHonoursProxyOverride(t *testing.T) {
+	newProxy := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+	}
+	configured := newProxy("configured")
+	defer configured.Close()
+	override := newProxy("override")
+	defer override.Close()
+	clearHTTPClientCache()
+	t.Cleanup(clearHTTPClientCache)
+
+	get := func(client *http.Client, ctx context.Context) string {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://upstream.invalid/ping", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	overrideCtx := util.WithProxyURL(context.Background(), override.URL)
+
+	cfg := &config.Config{}
+	cfg.ProxyURL = configured.URL
+	proxied := newProxyAwareHTTPClient(context.Background(), cfg, nil, 0)
+	if got := get(proxied, context.Background()); got != "configured" {
+		t.Errorf("Expected configured proxy without override, got %q", got)
+	}
+	if got := get(proxied, overrideCtx); got != "override" {
+		t.Errorf("Expected override proxy, got %q", got)
+	}
+
+	direct := newProxyAwareHTTPClient(context.Background(), &config.Config{}, nil, 0)
+	if got := get(direct, overrideCtx); got != "override" {
+		t.Errorf("Expected direct client to use the override proxy, got %q", got)
+	}
+}
+
+func TestNewProxyAwareHTTPClientRoutesPerCredential(t *testing.T) {
+	newProxy := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Host != "upstream.invalid" {
+				http.Error(w, "not proxied", http.StatusBadGateway)
+				return
+			}
+			_, _ = io.WriteString(w, name)
+		}))
+	}
+	global := newProxy("global")
+	defer global.Close()
+	tenantA := newProxy("tenant-a")
+	defer tenantA.Close()
+	tenantB := newProxy("tenant-b")
+	defer tenantB.Close()
+	clearHTTPClientCache()
+	t.Cleanup(clearHTTPClientCache)
+
+	cfg := &config.Config{}
+	cfg.ProxyURL = global.URL
+	tests := []struct {
+		auth *cliproxyauth.Auth
+		want string
+	}{
+		{auth: &cliproxyauth.Auth{ProxyURL: tenantA.URL}, want: "tenant-a"},
+		{auth: &cliproxyauth.Auth{ProxyURL: tenantB.URL}, want: "tenant-b"},
+		{auth: &cliproxyauth.Auth{}, want: "global"},
+	}
+	for _, tt := range tests {
+		client := newProxyAwareHTTPClient(context.Background(), cfg, tt.auth, 0)
+		resp, err := client.Get("http://upstream.invalid/ping")
+		if err != nil {
+			t.Fatalf("request for %q failed: %v", tt.want, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("Expected request routed through %q, got %q", tt.want, body)
+		}
+	}
+}
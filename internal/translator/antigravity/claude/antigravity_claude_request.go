@@ -7,16 +7,270 @@ package claude
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/thinking"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/translator/gemini/common"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
+// emptyMessagesPlaceholder is the text of the user turn injected when a request has no messages,
+// and of the filler turns inserted to enforce role alternation.
+const emptyMessagesPlaceholder = "."
+
+const (
+	// minTopK and maxTopK bound the integer topK forwarded to Gemini.
+	minTopK = 1
+	maxTopK = 500
+)
+
+var (
+	schemaSanitizer   util.SchemaSanitizer = util.AntigravitySchemaSanitizer
+	schemaSanitizerMu sync.RWMutex
+)
+
+// SetSchemaSanitizer replaces the sanitizer applied to tool schemas.
+// Passing nil restores the default util.AntigravitySchemaSanitizer.
+func SetSchemaSanitizer(sanitizer util.SchemaSanitizer) {
+	if sanitizer == nil {
+		sanitizer = util.AntigravitySchemaSanitizer
+	}
+	schemaSanitizerMu.Lock()
+	schemaSanitizer = sanitizer
+	schemaSanitizerMu.Unlock()
+}
+
+func currentSchemaSanitizer() util.SchemaSanitizer {
+	schemaSanitizerMu.RLock()
+	defer schemaSanitizerMu.RUnlock()
+	return schemaSanitizer
+}
+
+// maxToolDeclarations caps the function declarations forwarded per request. <= 0 means no cap.
+var maxToolDeclarations atomic.Int64
+
+// SetMaxToolDeclarations sets the maximum number of function declarations forwarded per request.
+// Tools beyond the cap are dropped in client order. A value <= 0 disables the cap.
+func SetMaxToolDeclarations(limit int) {
+	maxToolDeclarations.Store(int64(limit))
+}
+
+// maxRequestParts caps the total number of parts across all contents. <= 0 disables the cap.
+var maxRequestParts atomic.Int64
+
+// SetMaxRequestParts sets the maximum number of parts forwarded across all contents of a request.
+// Oldest turns are dropped until the request fits, and a last turn that alone exceeds the cap
+// keeps only its newest parts. A value <= 0 disables the cap.
+func SetMaxRequestParts(limit int) {
+	maxRequestParts.Store(int64(limit))
+}
+
+// maxThinkingParts caps the signed thought parts replayed from history. <= 0 disables the cap.
+var maxThinkingParts atomic.Int64
+
+// SetMaxThinkingParts sets how many of the most recent thought parts are replayed to Antigravity.
+// Older thought parts are dropped; a value <= 0 keeps all of them.
+func SetMaxThinkingParts(limit int) {
+	maxThinkingParts.Store(int64(limit))
+}
+
+// enforceTurnAlternation enables inserting filler turns so contents strictly alternate roles.
+var enforceTurnAlternation atomic.Bool
+
+// SetEnforceTurnAlternation toggles inserting minimal filler turns so contents start with a user
+// turn and alternate user/model, for targets that reject other shapes. It is off by default.
+func SetEnforceTurnAlternation(enabled bool) {
+	enforceTurnAlternation.Store(enabled)
+}
+
+// defaultMaxOutputTokens is forwarded as maxOutputTokens when a request omits max_tokens. <= 0 disables it.
+var defaultMaxOutputTokens atomic.Int64
+
+// SetDefaultMaxOutputTokens sets the maxOutputTokens applied when a Claude request omits max_tokens.
+// A value <= 0 leaves the upstream default in place.
+func SetDefaultMaxOutputTokens(tokens int) {
+	defaultMaxOutputTokens.Store(int64(tokens))
+}
+
+// sanitizeTextParts enables stripping invalid UTF-8 and control characters from text parts.
+var sanitizeTextParts atomic.Bool
+
+// SetSanitizeTextParts toggles removal of invalid UTF-8 sequences and control characters (other
+// than tab, newline and carriage return) from system, message and tool_result text. It is off by default.
+func SetSanitizeTextParts(enabled bool) {
+	sanitizeTextParts.Store(enabled)
+}
+
+// metadataLabels enables forwarding Claude metadata as Gemini request labels.
+var metadataLabels atomic.Bool
+
+// SetMetadataLabels toggles emitting request.labels (user_id and session_id) derived from the
+// Claude metadata. It is off by default.
+func SetMetadataLabels(enabled bool) {
+	metadataLabels.Store(enabled)
+}
+
+// Interleaved thinking hint modes select when the hint is added to the systemInstruction.
+const (
+	// InterleavedHintTools adds the hint only when thinking is enabled and tools are declared.
+	InterleavedHintTools = "tools"
+	// InterleavedHintAlways adds the hint whenever thinking is enabled, with or without tools.
+	InterleavedHintAlways = "always"
+	// InterleavedHintNever never adds the hint.
+	InterleavedHintNever = "never"
+)
+
+var interleavedHintMode atomic.Value // string
+
+// SetInterleavedThinkingHint selects when the interleaved thinking hint is injected.
+// Unknown or empty values fall back to InterleavedHintTools.
+func SetInterleavedThinkingHint(mode string) {
+	switch mode {
+	case InterleavedHintAlways, InterleavedHintNever:
+	default:
+		mode = InterleavedHintTools
+	}
+	interleavedHintMode.Store(mode)
+}
+
+// Unsigned thinking modes select what happens to history thinking blocks without a valid signature.
+const (
+	// UnsignedThinkingDrop removes unsigned thinking blocks.
+	UnsignedThinkingDrop = "drop"
+	// UnsignedThinkingStripToText forwards the thinking text of unsigned blocks as plain text parts.
+	UnsignedThinkingStripToText = "strip-to-text"
+)
+
+var unsignedThinkingMode atomic.Value // string
+
+// SetUnsignedThinkingMode selects how thinking blocks without a valid signature are converted.
+// Unknown or empty values fall back to UnsignedThinkingDrop.
+func SetUnsignedThinkingMode(mode string) {
+	switch mode {
+	case UnsignedThinkingStripToText:
+	default:
+		mode = UnsignedThinkingDrop
+	}
+	unsignedThinkingMode.Store(mode)
+}
+
+// maxLabelLength is Gemini's limit for label keys and values.
+const maxLabelLength = 63
+
+// requestLabels maps metadata.user_id and the derived session ID to Gemini labels. Values are
+// lowercased and characters outside [a-z0-9_-] become "_", as Gemini requires.
+func requestLabels(rawJSON []byte) map[string]string {
+	labels := make(map[string]string)
+	if value := sanitizeLabelValue(gjson.GetBytes(rawJSON, "metadata.user_id").String()); value != "" {
+		labels["user_id"] = value
+	}
+	if value := sanitizeLabelValue(DeriveSessionID(rawJSON)); value != "" {
+		labels["session_id"] = value
+	}
+	return labels
+}
+
+func sanitizeLabelValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(value))
+	if len(value) > maxLabelLength {
+		value = value[:maxLabelLength]
+	}
+	return value
+}
+
+func sanitizeTextPart(text string) string {
+	if !sanitizeTextParts.Load() {
+		return text
+	}
+	text = strings.ToValidUTF8(text, "")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// roleLessSystemInstructionModels lists models whose systemInstruction must not carry a role.
+// Entries ending in "*" match by prefix.
+var (
+	roleLessSystemInstructionModels   []string
+	roleLessSystemInstructionModelsMu sync.RWMutex
+)
+
+// SetRoleLessSystemInstructionModels configures the models whose systemInstruction is emitted
+// without a role field. Entries ending in "*" match any model with that prefix. All other models
+// keep the "user" role accepted by Antigravity.
+func SetRoleLessSystemInstructionModels(models []string) {
+	roleLessSystemInstructionModelsMu.Lock()
+	roleLessSystemInstructionModels = append([]string(nil), models...)
+	roleLessSystemInstructionModelsMu.Unlock()
+}
+
+func omitSystemInstructionRole(modelName string) bool {
+	roleLessSystemInstructionModelsMu.RLock()
+	defer roleLessSystemInstructionModelsMu.RUnlock()
+	for _, pattern := range roleLessSystemInstructionModels {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(modelName, prefix) {
+				return true
+			}
+		} else if pattern == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestOptions carries request metadata the body alone does not convey, such as
+// anthropic-beta flags sent as headers by Claude clients.
+type RequestOptions struct {
+	// AnthropicBetas lists the beta flags from the inbound anthropic-beta header.
+	AnthropicBetas []string
+}
+
+// AnthropicBetasFromHeaders splits the comma-separated anthropic-beta header values.
+func AnthropicBetasFromHeaders(headers http.Header) []string {
+	var betas []string
+	for _, value := range headers.Values("Anthropic-Beta") {
+		for _, beta := range strings.Split(value, ",") {
+			if beta = strings.TrimSpace(beta); beta != "" {
+				betas = append(betas, beta)
+			}
+		}
+	}
+	return betas
+}
+
+// hasBeta reports whether a beta flag with the given prefix (ignoring its date suffix) is set.
+func (o RequestOptions) hasBeta(prefix string) bool {
+	for _, beta := range o.AnthropicBetas {
+		if strings.HasPrefix(beta, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // ConvertClaudeRequestToAntigravity parses and transforms a Claude Code API request into Gemini CLI API format.
 // It extracts the model name, system instruction, message contents, and tool declarations
 // from the raw JSON request and returns them in the format expected by the Gemini CLI API.
@@ -35,7 +289,32 @@ import (
 //
 // Returns:
 //   - []byte: The transformed request data in Gemini CLI API format
-func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _ bool) []byte {
+//
+// The request is not decoded into Go structs: gjson scans the raw bytes lazily, so the
+// cost is dominated by the sjson writes of the output. A streaming emitter would not lower
+// time-to-first-upstream-byte because the upstream HTTP body is sent only once the complete
+// JSON document (including generationConfig and tools, which follow messages) is built.
+func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, stream bool) []byte {
+	return ConvertClaudeRequestToAntigravityWithOptions(modelName, inputRawJSON, stream, RequestOptions{})
+}
+
+// ConvertClaudeRequestToAntigravityWithOptions behaves like ConvertClaudeRequestToAntigravity
+// and additionally honors header-derived options. The interleaved-thinking beta forces the
+// interleaved thinking hint even for models the thinking-model heuristic does not recognize.
+func ConvertClaudeRequestToAntigravityWithOptions(modelName string, inputRawJSON []byte, _ bool, opts RequestOptions) []byte {
+	out, _ := convertClaudeRequest(modelName, inputRawJSON, opts)
+	return out
+}
+
+// ConvertClaudeRequestToAntigravityWithWarnings behaves like ConvertClaudeRequestToAntigravityWithOptions
+// and also returns the warnings collected while converting: content the converter dropped or
+// rewrote because Antigravity would reject it. Warnings are returned in the order they occurred.
+func ConvertClaudeRequestToAntigravityWithWarnings(modelName string, inputRawJSON []byte, _ bool, opts RequestOptions) ([]byte, []Warning) {
+	return convertClaudeRequest(modelName, inputRawJSON, opts)
+}
+
+func convertClaudeRequest(modelName string, inputRawJSON []byte, opts RequestOptions) ([]byte, []Warning) {
+	var warnings conversionWarnings
 	enableThoughtTranslate := true
 	rawJSON := bytes.Clone(inputRawJSON)
 
@@ -50,7 +329,7 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 			systemPromptResult := systemResults[i]
 			systemTypePromptResult := systemPromptResult.Get("type")
 			if systemTypePromptResult.Type == gjson.String && systemTypePromptResult.String() == "text" {
-				systemPrompt := systemPromptResult.Get("text").String()
+				systemPrompt := sanitizeTextPart(systemPromptResult.Get("text").String())
 				partJSON := `{}`
 				if systemPrompt != "" {
 					partJSON, _ = sjson.Set(partJSON, "text", systemPrompt)
@@ -59,16 +338,32 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 				hasSystemInstruction = true
 			}
 		}
+	} else if systemResult.IsObject() && systemResult.Get("type").String() == "text" {
+		// Some clients send a single system block instead of an array
+		systemInstructionJSON = `{"role":"user","parts":[{"text":""}]}`
+		systemInstructionJSON, _ = sjson.Set(systemInstructionJSON, "parts.0.text", sanitizeTextPart(systemResult.Get("text").String()))
+		hasSystemInstruction = true
 	} else if systemResult.Type == gjson.String {
 		systemInstructionJSON = `{"role":"user","parts":[{"text":""}]}`
-		systemInstructionJSON, _ = sjson.Set(systemInstructionJSON, "parts.0.text", systemResult.String())
+		systemInstructionJSON, _ = sjson.Set(systemInstructionJSON, "parts.0.text", sanitizeTextPart(systemResult.String()))
 		hasSystemInstruction = true
 	}
 
 	// contents
-	contentsJSON := "[]"
+	// Turns and their parts are collected as raw JSON and joined once, so large inline data is
+	// not copied again for every part or turn appended.
+	var contents []string
 	hasContents := false
 
+	// toolUseNames maps emitted tool_use IDs to their function names so tool_result blocks
+	// can be matched to an earlier functionCall.
+	toolUseNames := make(map[string]string)
+	// pendingToolUses lists emitted tool calls not yet answered, oldest first, so a tool_result
+	// that names its tool instead of an ID can be matched.
+	var pendingToolUses []pendingToolUse
+	// cachedToolResults counts converted tool_result blocks carrying cache_control.
+	cachedToolResults := 0
+
 	messagesResult := gjson.GetBytes(rawJSON, "messages")
 	if messagesResult.IsArray() {
 		messageResults := messagesResult.Array()
@@ -86,13 +381,31 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 			}
 			clientContentJSON := `{"role":"","parts":[]}`
 			clientContentJSON, _ = sjson.Set(clientContentJSON, "role", role)
+			var messageParts []string
 			contentsResult := messageResult.Get("content")
 			if contentsResult.IsArray() {
 				contentResults := contentsResult.Array()
 				numContents := len(contentResults)
+				// Scoped to this message: identical thinking text in another turn resolves to the
+				// same cached signature, but must never sign that turn's tool calls from here.
 				var currentMessageThinkingSignature string
+				// Unsigned blocks are dropped one by one; only a message left without any signed
+				// thinking forces thinking off for the request.
+				messageHasSignedThinking, messageHasUnsignedThinking := false, false
+				// Function responses are collected separately and placed before all other parts of
+				// the turn, each followed by its images, so text a client sends alongside tool
+				// results always comes after them regardless of block order.
+				var functionResponseParts []string
 				for j := 0; j < numContents; j++ {
 					contentResult := contentResults[j]
+					if contentResult.Type == gjson.String {
+						// Some clients mix bare strings into the block array; treat them as text blocks
+						if prompt := sanitizeTextPart(contentResult.String()); strings.TrimSpace(prompt) != "" {
+							partJSON, _ := sjson.Set(`{}`, "text", prompt)
+							messageParts = append(messageParts, partJSON)
+						}
+						continue
+					}
 					contentTypeResult := contentResult.Get("type")
 					if contentTypeResult.Type == gjson.String && contentTypeResult.String() == "thinking" {
 						// Use GetThinkingText to handle wrapped thinking objects
@@ -134,14 +447,21 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 						// Skip trailing unsigned thinking blocks on last assistant message
 						isUnsigned := !cache.HasValidSignature(modelName, signature)
 
-						// If unsigned, skip entirely (don't convert to text)
-						// Claude requires assistant messages to start with thinking blocks when thinking is enabled
-						// Converting to text would break this requirement
+						// Unsigned blocks follow unsignedThinkingMode: "drop" (default) removes them, since
+						// upstream rejects thought parts it cannot verify, and "strip-to-text" sends the text
+						// as a plain part. Only dropped blocks count towards disabling thinking below.
 						if isUnsigned {
-							// log.Debugf("Dropping unsigned thinking block (no valid signature)")
-							enableThoughtTranslate = false
+							mode, _ := unsignedThinkingMode.Load().(string)
+							if thinkingText != "" && mode == UnsignedThinkingStripToText {
+								partJSON, _ := sjson.Set(`{}`, "text", thinkingText)
+								messageParts = append(messageParts, partJSON)
+								continue
+							}
+							messageHasUnsignedThinking = true
+							warnings.add(WarningDroppedUnsignedThinking, "dropping thinking block without a valid signature")
 							continue
 						}
+						messageHasSignedThinking = true
 
 						// Valid signature, send as thought block
 						partJSON := `{}`
@@ -152,14 +472,16 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 						if signature != "" {
 							partJSON, _ = sjson.Set(partJSON, "thoughtSignature", signature)
 						}
-						clientContentJSON, _ = sjson.SetRaw(clientContentJSON, "parts.-1", partJSON)
+						messageParts = append(messageParts, partJSON)
 					} else if contentTypeResult.Type == gjson.String && contentTypeResult.String() == "text" {
-						prompt := contentResult.Get("text").String()
-						partJSON := `{}`
-						if prompt != "" {
-							partJSON, _ = sjson.Set(partJSON, "text", prompt)
+						prompt := sanitizeTextPart(contentResult.Get("text").String())
+						// Whitespace-only blocks become parts Gemini treats as empty; non-blank text is kept verbatim
+						if strings.TrimSpace(prompt) == "" {
+							continue
 						}
-						clientContentJSON, _ = sjson.SetRaw(clientContentJSON, "parts.-1", partJSON)
+						partJSON := `{}`
+						partJSON, _ = sjson.Set(partJSON, "text", prompt)
+						messageParts = append(messageParts, partJSON)
 					} else if contentTypeResult.Type == gjson.String && contentTypeResult.String() == "tool_use" {
 						// NOTE: Do NOT inject dummy thinking blocks here.
 						// Antigravity API validates signatures, so dummy values are rejected.
@@ -197,27 +519,79 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 							if functionID != "" {
 								partJSON, _ = sjson.Set(partJSON, "functionCall.id", functionID)
 							}
-							partJSON, _ = sjson.Set(partJSON, "functionCall.name", functionName)
+							// Names are sanitized like the declarations, so replayed calls match them.
+							partJSON, _ = sjson.Set(partJSON, "functionCall.name", util.SanitizeFunctionName(functionName))
 							partJSON, _ = sjson.SetRaw(partJSON, "functionCall.args", argsRaw)
-							clientContentJSON, _ = sjson.SetRaw(clientContentJSON, "parts.-1", partJSON)
+							messageParts = append(messageParts, partJSON)
+							if functionID != "" {
+								toolUseNames[functionID] = functionName
+							}
+							pendingToolUses = append(pendingToolUses, pendingToolUse{id: functionID, name: functionName})
 						}
 					} else if contentTypeResult.Type == gjson.String && contentTypeResult.String() == "tool_result" {
 						toolCallID := contentResult.Get("tool_use_id").String()
-						if toolCallID != "" {
-							funcName := toolCallID
-							toolCallIDs := strings.Split(toolCallID, "-")
-							if len(toolCallIDs) > 1 {
-								funcName = strings.Join(toolCallIDs[0:len(toolCallIDs)-2], "-")
+						resultName := ""
+						if toolCallID == "" {
+							// Some clients omit tool_use_id and name the tool instead; answer the
+							// oldest unanswered call with that name.
+							if resultName = contentResult.Get("name").String(); resultName != "" {
+								idx := oldestPendingToolUse(pendingToolUses, "", resultName)
+								if idx < 0 {
+									warnings.add(WarningDroppedToolResult, "dropping tool_result for %s without a matching tool_use", resultName)
+									continue
+								}
+								toolCallID = pendingToolUses[idx].id
+							}
+						}
+						if toolCallID != "" || resultName != "" {
+							// Gemini rejects a functionResponse without a matching earlier functionCall
+							funcName, matched := toolUseNames[toolCallID]
+							if resultName != "" {
+								funcName, matched = resultName, true
+							}
+							if !matched {
+								warnings.add(WarningDroppedToolResult, "dropping tool_result %s without a matching tool_use", toolCallID)
+								continue
+							}
+							if idx := oldestPendingToolUse(pendingToolUses, toolCallID, funcName); idx >= 0 {
+								pendingToolUses = append(pendingToolUses[:idx], pendingToolUses[idx+1:]...)
+							}
+							if funcName == "" {
+								funcName = toolCallID
+								toolCallIDs := strings.Split(toolCallID, "-")
+								if len(toolCallIDs) > 1 {
+									funcName = strings.Join(toolCallIDs[0:len(toolCallIDs)-2], "-")
+								}
+							}
+							if contentResult.Get("cache_control").Exists() {
+								cachedToolResults++
 							}
 							functionResponseResult := contentResult.Get("content")
 
 							functionResponseJSON := `{}`
-							functionResponseJSON, _ = sjson.Set(functionResponseJSON, "id", toolCallID)
-							functionResponseJSON, _ = sjson.Set(functionResponseJSON, "name", funcName)
+							if toolCallID != "" {
+								functionResponseJSON, _ = sjson.Set(functionResponseJSON, "id", toolCallID)
+							}
+							functionResponseJSON, _ = sjson.Set(functionResponseJSON, "name", util.SanitizeFunctionName(funcName))
 
+							// Typed blocks with images: text becomes the result and images follow as inline parts
+							var imageParts []string
 							responseData := ""
-							if functionResponseResult.Type == gjson.String {
-								responseData = functionResponseResult.String()
+							if functionResponseResult.IsArray() && hasImageBlock(functionResponseResult) {
+								var texts []string
+								for _, block := range functionResponseResult.Array() {
+									switch block.Get("type").String() {
+									case "text":
+										texts = append(texts, sanitizeTextPart(block.Get("text").String()))
+									case "image":
+										if imagePart, ok := imagePartFromSource(block.Get("source"), &warnings); ok {
+											imageParts = append(imageParts, imagePart)
+										}
+									}
+								}
+								functionResponseJSON, _ = sjson.Set(functionResponseJSON, "response.result", strings.Join(texts, "\n"))
+							} else if functionResponseResult.Type == gjson.String {
+								responseData = sanitizeTextPart(functionResponseResult.String())
 								functionResponseJSON, _ = sjson.Set(functionResponseJSON, "response.result", responseData)
 							} else if functionResponseResult.IsArray() {
 								frResults := functionResponseResult.Array()
@@ -229,93 +603,189 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 
 							} else if functionResponseResult.IsObject() {
 								functionResponseJSON, _ = sjson.SetRaw(functionResponseJSON, "response.result", functionResponseResult.Raw)
+							} else if !functionResponseResult.Exists() {
+								// content is optional in Claude; an empty raw value would corrupt the part
+								functionResponseJSON, _ = sjson.Set(functionResponseJSON, "response.result", "")
 							} else {
 								functionResponseJSON, _ = sjson.SetRaw(functionResponseJSON, "response.result", functionResponseResult.Raw)
 							}
 
 							partJSON := `{}`
 							partJSON, _ = sjson.SetRaw(partJSON, "functionResponse", functionResponseJSON)
-							clientContentJSON, _ = sjson.SetRaw(clientContentJSON, "parts.-1", partJSON)
+							functionResponseParts = append(functionResponseParts, partJSON)
+							functionResponseParts = append(functionResponseParts, imageParts...)
+						} else {
+							warnings.add(WarningDroppedToolResult, "dropping tool_result without tool_use_id or name")
 						}
 					} else if contentTypeResult.Type == gjson.String && contentTypeResult.String() == "image" {
-						sourceResult := contentResult.Get("source")
-						if sourceResult.Get("type").String() == "base64" {
-							inlineDataJSON := `{}`
-							if mimeType := sourceResult.Get("media_type").String(); mimeType != "" {
-								inlineDataJSON, _ = sjson.Set(inlineDataJSON, "mime_type", mimeType)
-							}
-							if data := sourceResult.Get("data").String(); data != "" {
-								inlineDataJSON, _ = sjson.Set(inlineDataJSON, "data", data)
-							}
-
-							partJSON := `{}`
-							partJSON, _ = sjson.SetRaw(partJSON, "inlineData", inlineDataJSON)
-							clientContentJSON, _ = sjson.SetRaw(clientContentJSON, "parts.-1", partJSON)
+						if partJSON, ok := imagePartFromSource(contentResult.Get("source"), &warnings); ok {
+							messageParts = append(messageParts, partJSON)
+						}
+					} else if contentTypeResult.Type == gjson.String && contentTypeResult.String() == "document" && contentResult.Get("source.type").String() == "text" {
+						// Plain-text documents carry their content inline, so they map to a text part
+						if partJSON, ok := documentTextPart(contentResult); ok {
+							messageParts = append(messageParts, partJSON)
 						}
+					} else {
+						warnings.add(WarningDroppedUnknownBlock, "dropping unsupported content block type %q", contentTypeResult.String())
 					}
 				}
 
-				// Reorder parts for 'model' role to ensure thinking block is first
+				if messageHasUnsignedThinking && !messageHasSignedThinking {
+					enableThoughtTranslate = false
+				}
+
+				if len(functionResponseParts) > 0 {
+					messageParts = append(functionResponseParts, messageParts...)
+				}
+
+				// Reorder parts for 'model' role to ensure thinking block is first. A turn cut off
+				// by max_tokens is replayed as generated so the model continues from where it stopped.
+				truncated := messageResult.Get("stop_reason").String() == "max_tokens"
 				if role == "model" {
-					partsResult := gjson.Get(clientContentJSON, "parts")
-					if partsResult.IsArray() {
-						parts := partsResult.Array()
-						var thinkingParts []gjson.Result
-						var otherParts []gjson.Result
-						for _, part := range parts {
-							if part.Get("thought").Bool() {
-								thinkingParts = append(thinkingParts, part)
-							} else {
-								otherParts = append(otherParts, part)
-							}
+					var thinkingParts, otherParts []string
+					for _, part := range messageParts {
+						if gjson.Get(part, "thought").Bool() {
+							thinkingParts = append(thinkingParts, part)
+						} else {
+							otherParts = append(otherParts, part)
 						}
-						if len(thinkingParts) > 0 {
-							firstPartIsThinking := parts[0].Get("thought").Bool()
-							if !firstPartIsThinking || len(thinkingParts) > 1 {
-								var newParts []interface{}
-								for _, p := range thinkingParts {
-									newParts = append(newParts, p.Value())
-								}
-								for _, p := range otherParts {
-									newParts = append(newParts, p.Value())
-								}
-								clientContentJSON, _ = sjson.Set(clientContentJSON, "parts", newParts)
-							}
+					}
+					if len(thinkingParts) > 0 {
+						firstPartIsThinking := gjson.Get(messageParts[0], "thought").Bool()
+						if !truncated && (!firstPartIsThinking || len(thinkingParts) > 1) {
+							messageParts = append(thinkingParts, otherParts...)
+						}
+						// Some Gemini versions reject model turns made only of thought parts
+						if len(otherParts) == 0 {
+							messageParts = append(messageParts, `{"text":""}`)
 						}
 					}
 				}
 
-				contentsJSON, _ = sjson.SetRaw(contentsJSON, "-1", clientContentJSON)
+				// Skip turns left without parts (e.g. only dropped blocks); Gemini rejects them
+				if len(messageParts) == 0 {
+					continue
+				}
+				contents = append(contents, withRawField(clientContentJSON, "parts", joinRawArray(messageParts)))
 				hasContents = true
 			} else if contentsResult.Type == gjson.String {
-				prompt := contentsResult.String()
-				partJSON := `{}`
-				if prompt != "" {
-					partJSON, _ = sjson.Set(partJSON, "text", prompt)
+				prompt := sanitizeTextPart(contentsResult.String())
+				if strings.TrimSpace(prompt) == "" {
+					continue
 				}
+				partJSON := `{}`
+				partJSON, _ = sjson.Set(partJSON, "text", prompt)
 				clientContentJSON, _ = sjson.SetRaw(clientContentJSON, "parts.-1", partJSON)
-				contentsJSON, _ = sjson.SetRaw(contentsJSON, "-1", clientContentJSON)
+				contents = append(contents, clientContentJSON)
 				hasContents = true
 			}
 		}
 	}
 
+	// Like tool cache_control, a tool_result breakpoint has no Antigravity cached-content mapping yet;
+	// the result itself is still sent, only uncached.
+	if cachedToolResults > 0 {
+		warnings.add(WarningIgnoredToolResultCacheControl, "cache_control on %d tool_result blocks is not supported upstream", cachedToolResults)
+	}
+
+	if limit := int(maxThinkingParts.Load()); limit > 0 {
+		var droppedThinking int
+		contents, droppedThinking = limitThinkingParts(contents, limit)
+		if droppedThinking > 0 {
+			log.Debugf("antigravity claude request: dropped %d thought parts beyond max-thinking-parts (%d)", droppedThinking, limit)
+			hasContents = len(contents) > 0
+		}
+	}
+
+	// Gemini rejects requests without contents, e.g. when the client sends only a system
+	// prompt and tools with an empty or missing messages array (a tool-availability probe).
+	// Inject a minimal user turn instead.
+	if !hasContents {
+		contents = append(contents, `{"role":"user","parts":[{"text":"`+emptyMessagesPlaceholder+`"}]}`)
+		hasContents = true
+	}
+	if enforceTurnAlternation.Load() {
+		var inserted int
+		if contents, inserted = alternateTurns(contents); inserted > 0 {
+			log.Debugf("antigravity claude request: inserted %d filler turns to alternate roles", inserted)
+		}
+	}
+	contentsJSON := joinRawArray(contents)
+
+	if limit := int(maxRequestParts.Load()); limit > 0 {
+		capped, result, errCap := capContentParts(contentsJSON, limit)
+		if errCap != nil {
+			warnings.add(WarningToolLoopOverLimit, "%v", errCap)
+		} else {
+			contentsJSON = capped
+		}
+		if result.droppedTurns > 0 {
+			warnings.add(WarningDroppedTurns, "dropped %d oldest turns (%d parts) beyond max-request-parts (%d)", result.droppedTurns, result.droppedParts, limit)
+		}
+		if result.orphanedResponses > 0 {
+			warnings.add(WarningDroppedToolResult, "dropped %d function responses of the last turn whose calls exceeded max-request-parts (%d)", result.orphanedResponses, limit)
+		}
+		if result.truncatedParts > 0 {
+			warnings.add(WarningTruncatedTurn, "dropped %d oldest parts of the last turn beyond max-request-parts (%d)", result.truncatedParts, limit)
+		}
+	}
+
 	// tools
 	toolsJSON := ""
 	toolDeclCount := 0
 	allowedToolKeys := []string{"name", "description", "behavior", "parameters", "parametersJsonSchema", "response", "responseJsonSchema"}
 	toolsResult := gjson.GetBytes(rawJSON, "tools")
 	if toolsResult.IsArray() {
+		// Declarations are appended in client order; models weight earlier tools, so any
+		// filtering or dedup here must keep first-seen order.
 		toolsJSON = `[{"functionDeclarations":[]}]`
+		toolLimit := int(maxToolDeclarations.Load())
+		droppedTools := 0
+		toolsCacheControl := false
+		rootDefs := requestSchemaDefinitions(rawJSON)
 		toolsResults := toolsResult.Array()
 		for i := 0; i < len(toolsResults); i++ {
 			toolResult := toolsResults[i]
+			if toolResult.Get("cache_control").Exists() {
+				toolsCacheControl = true
+			}
 			inputSchemaResult := toolResult.Get("input_schema")
 			if inputSchemaResult.Exists() && inputSchemaResult.IsObject() {
+				if toolLimit > 0 && toolDeclCount >= toolLimit {
+					droppedTools++
+					continue
+				}
+				// Schemas the client already authored for Gemini skip the Claude-oriented
+				// cleaner, which could otherwise rewrite keywords Gemini accepts.
+				geminiNative := toolResult.Get(util.GeminiNativeSchemaMarker).Bool() || util.IsGeminiNativeSchema(inputSchemaResult.Raw)
+				cleanSchema := func(schema string) string {
+					if geminiNative {
+						return util.StripGeminiNativeMarker(schema)
+					}
+					return currentSchemaSanitizer().Clean(schema)
+				}
 				// Sanitize the input schema for Antigravity API compatibility
-				inputSchema := util.CleanJSONSchemaForAntigravity(inputSchemaResult.Raw)
+				inputSchemaRaw := inputSchemaResult.Raw
+				if rootDefs != "" && !geminiNative {
+					inputSchemaRaw = util.ResolveSchemaRefs(inputSchemaRaw, rootDefs)
+				}
+				inputSchema := cleanSchema(inputSchemaRaw)
 				tool, _ := sjson.Delete(toolResult.Raw, "input_schema")
+				// Gemini rejects names outside [a-zA-Z0-9_.:-]; the response translator maps them back.
+				if name := toolResult.Get("name").String(); name != "" {
+					if sanitized := util.SanitizeFunctionName(name); sanitized != name {
+						tool, _ = sjson.Set(tool, "name", sanitized)
+						warnings.add(WarningSanitizedToolName, "renamed tool %q to %q to satisfy Gemini function naming rules", name, sanitized)
+					}
+				}
 				tool, _ = sjson.SetRaw(tool, "parametersJsonSchema", inputSchema)
+				// Response schemas pass through but must be sanitized like input schemas
+				for _, responseKey := range []string{"response", "responseJsonSchema"} {
+					if responseSchema := gjson.Get(tool, responseKey); responseSchema.IsObject() {
+						tool, _ = sjson.SetRaw(tool, responseKey, cleanSchema(responseSchema.Raw))
+					}
+				}
 				for toolKey := range gjson.Parse(tool).Map() {
 					if util.InArray(allowedToolKeys, toolKey) {
 						continue
@@ -326,51 +796,78 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 				toolDeclCount++
 			}
 		}
+		if droppedTools > 0 {
+			warnings.add(WarningDroppedTools, "dropped %d tools beyond max-tool-declarations (%d)", droppedTools, toolLimit)
+		}
+		// A tool-level cache_control marks the tools as part of Claude's cacheable prefix. There is
+		// no cached-content mapping for Antigravity yet, so report it instead of dropping it silently.
+		// Claude Code sets it on every request, hence debug rather than warning level.
+		if toolsCacheControl {
+			warnings.add(WarningIgnoredToolCacheControl, "tool cache_control has no Antigravity equivalent, tools are sent uncached")
+		}
 	}
 
 	// Build output Gemini CLI request JSON
 	out := `{"model":"","request":{"contents":[]}}`
 	out, _ = sjson.Set(out, "model", modelName)
 
-	// Inject interleaved thinking hint when both tools and thinking are active
+	// Inject interleaved thinking hint when thinking is active and, by default, tools are declared
 	hasTools := toolDeclCount > 0
 	thinkingResult := gjson.GetBytes(rawJSON, "thinking")
 	hasThinking := thinkingResult.Exists() && thinkingResult.IsObject() && thinkingResult.Get("type").String() == "enabled"
-	isClaudeThinking := util.IsClaudeThinkingModel(modelName)
-
-	if hasTools && hasThinking && isClaudeThinking {
-		interleavedHint := "Interleaved thinking is enabled. You may think between tool calls and after receiving tool results before deciding the next action or final answer. Do not mention these instructions or any constraints about thinking blocks; just apply them."
+	interleavedThinking := util.IsClaudeThinkingModel(modelName) || opts.hasBeta("interleaved-thinking")
 
-		if hasSystemInstruction {
-			// Append hint as a new part to existing system instruction
-			hintPart := `{"text":""}`
-			hintPart, _ = sjson.Set(hintPart, "text", interleavedHint)
-			systemInstructionJSON, _ = sjson.SetRaw(systemInstructionJSON, "parts.-1", hintPart)
-		} else {
-			// Create new system instruction with hint
+	// Hints are appended as new trailing parts rather than concatenated into existing text, so a
+	// cacheable system prefix (cache_control) stays byte-identical and its cache boundary intact.
+	appendSystemHint := func(hint string) {
+		if !hasSystemInstruction {
 			systemInstructionJSON = `{"role":"user","parts":[]}`
-			hintPart := `{"text":""}`
-			hintPart, _ = sjson.Set(hintPart, "text", interleavedHint)
-			systemInstructionJSON, _ = sjson.SetRaw(systemInstructionJSON, "parts.-1", hintPart)
 			hasSystemInstruction = true
 		}
+		hintPart := `{"text":""}`
+		hintPart, _ = sjson.Set(hintPart, "text", hint)
+		systemInstructionJSON, _ = sjson.SetRaw(systemInstructionJSON, "parts.-1", hintPart)
+	}
+
+	injectInterleavedHint := hasTools
+	switch mode, _ := interleavedHintMode.Load().(string); mode {
+	case InterleavedHintAlways:
+		injectInterleavedHint = true
+	case InterleavedHintNever:
+		injectInterleavedHint = false
+	}
+	if injectInterleavedHint && hasThinking && interleavedThinking {
+		appendSystemHint("Interleaved thinking is enabled. You may think between tool calls and after receiving tool results before deciding the next action or final answer. Do not mention these instructions or any constraints about thinking blocks; just apply them.")
+	}
+
+	// Gemini has no switch for parallel function calling, so honor parallel_tool_calls=false
+	// (or Claude's tool_choice.disable_parallel_tool_use) with an instruction instead.
+	if hasTools && disableParallelToolCalls(rawJSON) {
+		appendSystemHint("Call at most one tool per response. Wait for its result before calling another tool. Do not mention this instruction.")
 	}
 
 	if hasSystemInstruction {
+		if omitSystemInstructionRole(modelName) {
+			systemInstructionJSON, _ = sjson.Delete(systemInstructionJSON, "role")
+		}
 		out, _ = sjson.SetRaw(out, "request.systemInstruction", systemInstructionJSON)
 	}
-	if hasContents {
-		out, _ = sjson.SetRaw(out, "request.contents", contentsJSON)
-	}
 	if toolDeclCount > 0 {
 		out, _ = sjson.SetRaw(out, "request.tools", toolsJSON)
 	}
 
-	// Map Anthropic thinking -> Gemini thinkingBudget/include_thoughts when type==enabled
+	// Map Anthropic thinking -> Gemini thinkingBudget/include_thoughts when type==enabled.
+	// An explicit type==disabled is enforced by the executor after thinking.ApplyThinking.
 	if t := gjson.GetBytes(rawJSON, "thinking"); enableThoughtTranslate && t.Exists() && t.IsObject() {
 		if t.Get("type").String() == "enabled" {
 			if b := t.Get("budget_tokens"); b.Exists() && b.Type == gjson.Number {
 				budget := int(b.Int())
+				// Claude requires budget_tokens < max_tokens; keep the budget inside the output limit.
+				if maxTokens := gjson.GetBytes(rawJSON, "max_tokens"); maxTokens.Type == gjson.Number && maxTokens.Int() > 0 && int64(budget) >= maxTokens.Int() {
+					clamped := int(maxTokens.Int()) - 1
+					warnings.add(WarningClampedBudget, "clamped thinking budget %d to %d below max_tokens", budget, clamped)
+					budget = clamped
+				}
 				out, _ = sjson.Set(out, "request.generationConfig.thinkingConfig.thinkingBudget", budget)
 				out, _ = sjson.Set(out, "request.generationConfig.thinkingConfig.includeThoughts", true)
 			}
@@ -383,14 +880,633 @@ func ConvertClaudeRequestToAntigravity(modelName string, inputRawJSON []byte, _
 		out, _ = sjson.Set(out, "request.generationConfig.topP", v.Num)
 	}
 	if v := gjson.GetBytes(rawJSON, "top_k"); v.Exists() && v.Type == gjson.Number {
-		out, _ = sjson.Set(out, "request.generationConfig.topK", v.Num)
+		out, _ = sjson.Set(out, "request.generationConfig.topK", normalizeTopK(v.Num))
 	}
 	if v := gjson.GetBytes(rawJSON, "max_tokens"); v.Exists() && v.Type == gjson.Number {
 		out, _ = sjson.Set(out, "request.generationConfig.maxOutputTokens", v.Num)
+	} else if fallback := defaultMaxOutputTokens.Load(); fallback > 0 {
+		out, _ = sjson.Set(out, "request.generationConfig.maxOutputTokens", fallback)
+	}
+
+	if metadataLabels.Load() {
+		labels := requestLabels(rawJSON)
+		for _, key := range []string{"user_id", "session_id"} {
+			if value, ok := labels[key]; ok {
+				out, _ = sjson.Set(out, "request.labels."+key, value)
+			}
+		}
 	}
 
 	outBytes := []byte(out)
+	// Per-request safety_settings ([{category, threshold}]) override the configured thresholds
+	if requestThresholds := requestSafetyThresholds(rawJSON); len(requestThresholds) > 0 {
+		withRequestSettings, errSafety := common.AttachSafetySettingsOverConfigured(outBytes, "request.safetySettings", requestThresholds)
+		if errSafety != nil {
+			warnings.add(WarningInvalidSafetySettings, "ignoring invalid safety_settings: %v", errSafety)
+		} else {
+			outBytes = withRequestSettings
+		}
+	}
 	outBytes = common.AttachDefaultSafetySettings(outBytes, "request.safetySettings")
 
-	return outBytes
+	// Contents go in last: they hold any inline image data, and every earlier write copies the document.
+	if hasContents {
+		outBytes = spliceRawBytes(outBytes, "request.contents", contentsJSON)
+	}
+
+	return outBytes, warnings
+}
+
+// Warning codes reported by ConvertClaudeRequestToAntigravityWithWarnings.
+const (
+	WarningDroppedUnknownBlock           = "dropped_unknown_block"
+	WarningDroppedUnsignedThinking       = "dropped_unsigned_thinking"
+	WarningDroppedToolResult             = "dropped_tool_result"
+	WarningDroppedTurns                  = "dropped_turns"
+	WarningTruncatedTurn                 = "truncated_turn"
+	WarningToolLoopOverLimit             = "tool_loop_over_limit"
+	WarningDroppedTools                  = "dropped_tools"
+	WarningUnsupportedImage              = "unsupported_image"
+	WarningInvalidSafetySettings         = "invalid_safety_settings"
+	WarningIgnoredToolCacheControl       = "ignored_tool_cache_control"
+	WarningIgnoredToolResultCacheControl = "ignored_tool_result_cache_control"
+	WarningClampedBudget                 = "clamped_budget"
+	WarningSanitizedToolName             = "sanitized_tool_name"
+)
+
+// Warning describes content the converter dropped or rewrote. Code is one of the Warning*
+// constants and stable across releases; Message is human-readable detail.
+type Warning struct {
+	Code    string
+	Message string
+}
+
+type conversionWarnings []Warning
+
+// add records a warning and logs it at debug level; callers surface the codes to clients.
+func (w *conversionWarnings) add(code, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	log.Debugf("antigravity claude request: %s", message)
+	*w = append(*w, Warning{Code: code, Message: message})
+}
+
+// joinRawArray joins raw JSON values into a JSON array with a single allocation.
+func joinRawArray(items []string) string {
+	size := 2 + len(items)
+	for _, item := range items {
+		size += len(item)
+	}
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(item)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// withRawField replaces the existing value at key with raw.
+// Unlike sjson.SetRaw it copies raw only once.
+func withRawField(object, key, raw string) string {
+	value := gjson.Get(object, key)
+	if !value.Exists() || value.Index == 0 {
+		object, _ = sjson.SetRaw(object, key, raw)
+		return object
+	}
+	var b strings.Builder
+	b.Grow(len(object) - len(value.Raw) + len(raw))
+	b.WriteString(object[:value.Index])
+	b.WriteString(raw)
+	b.WriteString(object[value.Index+len(value.Raw):])
+	return b.String()
+}
+
+// spliceRawBytes replaces the existing value at path with raw, copying raw only once.
+func spliceRawBytes(doc []byte, path, raw string) []byte {
+	value := gjson.GetBytes(doc, path)
+	if !value.Exists() || value.Index == 0 {
+		out, _ := sjson.SetRawBytes(doc, path, []byte(raw))
+		return out
+	}
+	out := make([]byte, 0, len(doc)-len(value.Raw)+len(raw))
+	out = append(out, doc[:value.Index]...)
+	out = append(out, raw...)
+	return append(out, doc[value.Index+len(value.Raw):]...)
+}
+
+// partCap counts what capContentParts removed to fit the part limit.
+type partCap struct {
+	droppedTurns, droppedParts int
+	// orphanedResponses are function responses of the last turn whose calls were dropped.
+	orphanedResponses int
+	// truncatedParts are the oldest parts cut from a last turn that alone exceeds the limit.
+	truncatedParts int
+}
+
+// capContentParts drops the oldest turns until the total part count is within limit. The last
+// turn is always kept, and the history is trimmed further so it starts with a user turn that
+// carries no function responses, since those would answer calls that were dropped. When only the
+// last turn survives and it holds such responses, they are dropped with their calls. A last turn
+// that alone exceeds limit keeps only its newest limit parts. A last turn made up entirely of
+// responses to calls that do not fit cannot be capped without breaking the tool loop, so an
+// error is returned instead.
+func capContentParts(contentsJSON string, limit int) (string, partCap, error) {
+	var result partCap
+	contents := gjson.Parse(contentsJSON).Array()
+	total := 0
+	for _, content := range contents {
+		total += len(content.Get("parts").Array())
+	}
+	if total <= limit {
+		return contentsJSON, result, nil
+	}
+
+	start := 0
+	for start < len(contents)-1 && total-result.droppedParts > limit {
+		result.droppedParts += len(contents[start].Get("parts").Array())
+		start++
+	}
+	for start < len(contents)-1 && !startsConversation(contents[start]) {
+		result.droppedParts += len(contents[start].Get("parts").Array())
+		start++
+	}
+	result.droppedTurns = start
+
+	kept := make([]string, 0, len(contents)-start)
+	for _, content := range contents[start:] {
+		kept = append(kept, content.Raw)
+	}
+	if last := contents[len(contents)-1]; start == len(contents)-1 {
+		var remaining []string
+		for _, part := range last.Get("parts").Array() {
+			if part.Get("functionResponse").Exists() {
+				result.orphanedResponses++
+				continue
+			}
+			remaining = append(remaining, part.Raw)
+		}
+		if len(remaining) == 0 {
+			return contentsJSON, partCap{}, fmt.Errorf("the last turn answers %d tool calls that do not fit in max-request-parts (%d)", result.orphanedResponses, limit)
+		}
+		if len(remaining) > limit {
+			result.truncatedParts = len(remaining) - limit
+			remaining = remaining[result.truncatedParts:]
+		}
+		if result.orphanedResponses > 0 || result.truncatedParts > 0 {
+			kept[0], _ = sjson.SetRaw(last.Raw, "parts", joinRawArray(remaining))
+		}
+	}
+	return "[" + strings.Join(kept, ",") + "]", result, nil
+}
+
+// limitThinkingParts keeps the newest limit thought parts across all model turns and removes
+// older ones. Tool calls carry their own thoughtSignature, so dropping the thought that produced a call
+// never orphans it. The last model turn is never touched, as it may be the turn of an active
+// tool loop whose thinking upstream requires. Its thoughts still count towards limit. Model
+// turns left with only the empty placeholder text are removed, and the turns around them are
+// merged when they share a role. It returns the remaining contents and the number of thought
+// parts dropped.
+func limitThinkingParts(contents []string, limit int) ([]string, int) {
+	seen, dropped := 0, 0
+	removeTurn := make(map[int]bool)
+	lastModelTurn := true
+	for i := len(contents) - 1; i >= 0; i-- {
+		content := gjson.Parse(contents[i])
+		if content.Get("role").String() != "model" {
+			continue
+		}
+		parts := content.Get("parts").Array()
+		if lastModelTurn {
+			lastModelTurn = false
+			for _, part := range parts {
+				if part.Get("thought").Bool() {
+					seen++
+				}
+			}
+			continue
+		}
+		keep := make([]bool, len(parts))
+		changed, substantive := false, false
+		for j := len(parts) - 1; j >= 0; j-- {
+			keep[j] = true
+			if parts[j].Get("thought").Bool() {
+				seen++
+				if seen > limit {
+					keep[j] = false
+					changed = true
+					dropped++
+				}
+				continue
+			}
+			if parts[j].Raw != `{"text":""}` {
+				substantive = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if !substantive {
+			removeTurn[i] = true
+			continue
+		}
+		kept := make([]string, 0, len(parts))
+		for j, part := range parts {
+			if keep[j] {
+				kept = append(kept, part.Raw)
+			}
+		}
+		contents[i] = withRawField(contents[i], "parts", joinRawArray(kept))
+	}
+	if len(removeTurn) == 0 {
+		return contents, dropped
+	}
+	remaining := contents[:0]
+	for i, content := range contents {
+		if removeTurn[i] {
+			continue
+		}
+		// A removed model turn can leave two user turns in a row; join them into one.
+		if n := len(remaining); n > 0 && removeTurn[i-1] && gjson.Get(remaining[n-1], "role").String() == gjson.Get(content, "role").String() {
+			remaining[n-1] = mergeTurnParts(remaining[n-1], content)
+			continue
+		}
+		remaining = append(remaining, content)
+	}
+	return remaining, dropped
+}
+
+// mergeTurnParts appends the parts of next to the parts of turn.
+func mergeTurnParts(turn, next string) string {
+	var parts []string
+	for _, part := range gjson.Get(turn, "parts").Array() {
+		parts = append(parts, part.Raw)
+	}
+	for _, part := range gjson.Get(next, "parts").Array() {
+		parts = append(parts, part.Raw)
+	}
+	return withRawField(turn, "parts", joinRawArray(parts))
+}
+
+// alternateTurns inserts a filler turn of the opposite role before a leading model turn and
+// between consecutive turns of the same role. It returns the new contents and the number of
+// fillers inserted.
+func alternateTurns(contents []string) ([]string, int) {
+	fillers := map[string]string{
+		"user":  `{"role":"model","parts":[{"text":"` + emptyMessagesPlaceholder + `"}]}`,
+		"model": `{"role":"user","parts":[{"text":"` + emptyMessagesPlaceholder + `"}]}`,
+	}
+	out := make([]string, 0, len(contents))
+	previous, inserted := "", 0
+	for _, content := range contents {
+		role := gjson.Get(content, "role").String()
+		if (previous == "" && role == "model") || (previous != "" && role == previous) {
+			if filler, ok := fillers[role]; ok {
+				out = append(out, filler)
+				inserted++
+			}
+		}
+		out = append(out, content)
+		previous = role
+	}
+	return out, inserted
+}
+
+func startsConversation(content gjson.Result) bool {
+	if content.Get("role").String() != "user" {
+		return false
+	}
+	for _, part := range content.Get("parts").Array() {
+		if part.Get("functionResponse").Exists() {
+			return false
+		}
+	}
+	return true
+}
+
+// disableParallelToolCalls reports whether the client asked for one tool call per turn, either
+// with the OpenAI-style parallel_tool_calls=false or Claude's tool_choice.disable_parallel_tool_use.
+func disableParallelToolCalls(rawJSON []byte) bool {
+	if v := gjson.GetBytes(rawJSON, "parallel_tool_calls"); v.Exists() && v.Type == gjson.False {
+		return true
+	}
+	return gjson.GetBytes(rawJSON, "tool_choice.disable_parallel_tool_use").Bool()
+}
+
+// pendingToolUse is an emitted functionCall awaiting its tool_result.
+type pendingToolUse struct {
+	id   string
+	name string
+}
+
+// oldestPendingToolUse returns the index of the first pending call with the given id, or with
+// the given name when id is empty, or -1 when there is none.
+func oldestPendingToolUse(pending []pendingToolUse, id, name string) int {
+	for i, call := range pending {
+		if id != "" {
+			if call.id == id {
+				return i
+			}
+		} else if call.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// requestSchemaDefinitions returns the shared schema definitions declared at the request root
+// ("$defs" and "definitions", the former winning on name clashes) that tool input schemas may
+// reference, or "" when there are none.
+func requestSchemaDefinitions(rawJSON []byte) string {
+	defs := make(map[string]json.RawMessage)
+	for _, key := range []string{"definitions", "$defs"} {
+		gjson.GetBytes(rawJSON, key).ForEach(func(name, value gjson.Result) bool {
+			if value.IsObject() {
+				defs[name.String()] = json.RawMessage(value.Raw)
+			}
+			return true
+		})
+	}
+	if len(defs) == 0 {
+		return ""
+	}
+	encoded, err := json.Marshal(defs)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// requestSafetyThresholds collects the category -> threshold pairs from a request's safety_settings.
+func requestSafetyThresholds(rawJSON []byte) map[string]string {
+	settings := gjson.GetBytes(rawJSON, "safety_settings")
+	if !settings.IsArray() {
+		return nil
+	}
+	thresholds := make(map[string]string)
+	for _, setting := range settings.Array() {
+		category, threshold := setting.Get("category").String(), setting.Get("threshold").String()
+		if category != "" && threshold != "" {
+			thresholds[category] = threshold
+		}
+	}
+	return thresholds
+}
+
+// normalizeTopK rounds a Claude top_k to the nearest integer and clamps it to [minTopK, maxTopK],
+// since Gemini treats topK as an integer.
+func normalizeTopK(topK float64) int {
+	rounded := math.Round(topK)
+	if math.IsNaN(rounded) || rounded < minTopK {
+		return minTopK
+	}
+	if rounded > maxTopK {
+		return maxTopK
+	}
+	return int(rounded)
+}
+
+// imagePartFromSource builds the Gemini part for a Claude image source. ok is false for non-base64
+// sources and for media types Gemini does not support, which are recorded as an unsupported_image
+// warning so ConvertClaudeRequestToAntigravityE can reject the request with the type named.
+func imagePartFromSource(sourceResult gjson.Result, warnings *conversionWarnings) (string, bool) {
+	if sourceResult.Get("type").String() != "base64" {
+		return "", false
+	}
+	mimeType := sourceResult.Get("media_type").String()
+	if mimeType != "" && !isSupportedImageMimeType(mimeType) {
+		warnings.add(WarningUnsupportedImage, "unsupported image media type %s (supported: %s)", mimeType, strings.Join(supportedImageMimeTypes, ", "))
+		return "", false
+	}
+	inlineDataJSON := `{}`
+	if mimeType != "" {
+		inlineDataJSON, _ = sjson.Set(inlineDataJSON, "mime_type", mimeType)
+	}
+	dataResult := sourceResult.Get("data")
+	if isCanonicalBase64JSON(dataResult) {
+		// Large images are typically already standard base64: splice the raw JSON string in
+		// with a single copy instead of unescaping, normalizing and re-escaping it.
+		partJSON := `{"inlineData":` + inlineDataJSON[:len(inlineDataJSON)-1]
+		if mimeType != "" {
+			partJSON += ","
+		}
+		return partJSON + `"data":` + dataResult.Raw + `}}`, true
+	}
+	if data := dataResult.String(); data != "" {
+		inlineDataJSON, _ = sjson.Set(inlineDataJSON, "data", normalizeBase64Data(data))
+	}
+	partJSON, _ := sjson.SetRaw(`{}`, "inlineData", inlineDataJSON)
+	return partJSON, true
+}
+
+// documentTextPart converts a document block with a plain-text source into a text part, prefixed
+// by the document title when present. Blank documents yield no part.
+func documentTextPart(document gjson.Result) (string, bool) {
+	text := sanitizeTextPart(document.Get("source.data").String())
+	if strings.TrimSpace(text) == "" {
+		return "", false
+	}
+	if title := strings.TrimSpace(document.Get("title").String()); title != "" {
+		text = title + "\n\n" + text
+	}
+	partJSON, _ := sjson.Set(`{}`, "text", text)
+	return partJSON, true
+}
+
+// isCanonicalBase64JSON reports whether a JSON string value holds padded standard base64 with no
+// escape sequences, i.e. its raw form can be forwarded unchanged.
+func isCanonicalBase64JSON(value gjson.Result) bool {
+	if value.Type != gjson.String || len(value.Raw) < 3 || len(value.Str)%4 != 0 || len(value.Raw) != len(value.Str)+2 {
+		return false
+	}
+	return !strings.ContainsAny(value.Raw[1:len(value.Raw)-1], "\\-_")
+}
+
+func hasImageBlock(blocks gjson.Result) bool {
+	for _, block := range blocks.Array() {
+		if block.Get("type").String() == "image" {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedImageMimeTypes lists the inline image types Gemini accepts.
+var supportedImageMimeTypes = []string{"image/png", "image/jpeg", "image/webp", "image/heic", "image/heif", "image/gif"}
+
+func isSupportedImageMimeType(mimeType string) bool {
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	for _, supported := range supportedImageMimeTypes {
+		if mimeType == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// base64URLReplacer maps the base64url alphabet onto standard base64.
+var base64URLReplacer = strings.NewReplacer("-", "+", "_", "/")
+
+// normalizeBase64Data converts base64url image data (with '-'/'_' and usually no padding)
+// to the standard padded base64 that Gemini expects. Standard base64 is returned unchanged.
+func normalizeBase64Data(data string) string {
+	if strings.ContainsAny(data, "-_") {
+		data = base64URLReplacer.Replace(data)
+	}
+	if rem := len(data) % 4; rem == 2 || rem == 3 {
+		data += strings.Repeat("=", 4-rem)
+	}
+	return data
+}
+
+// ConvertClaudeRequestToAntigravityE converts the request like ConvertClaudeRequestToAntigravityWithWarnings
+// but rejects a body that is not a JSON object, returning the parse error so callers can answer with 400
+// instead of forwarding a degraded request. Images with a media type Gemini does not support are
+// rejected the same way, as is a tool loop that cannot fit in max-request-parts. The registry
+// converter cannot fail and drops such images instead.
+func ConvertClaudeRequestToAntigravityE(modelName string, inputRawJSON []byte, stream bool, opts RequestOptions) ([]byte, []Warning, error) {
+	if !gjson.ValidBytes(inputRawJSON) {
+		var raw json.RawMessage
+		if errParse := json.Unmarshal(inputRawJSON, &raw); errParse != nil {
+			return nil, nil, fmt.Errorf("invalid claude request JSON: %w", errParse)
+		}
+		return nil, nil, fmt.Errorf("invalid claude request JSON")
+	}
+	if root := gjson.ParseBytes(inputRawJSON); !root.IsObject() {
+		return nil, nil, fmt.Errorf("invalid claude request: expected a JSON object, got %s", root.Type)
+	}
+	out, warnings := ConvertClaudeRequestToAntigravityWithWarnings(modelName, inputRawJSON, stream, opts)
+	for _, warning := range warnings {
+		if warning.Code == WarningUnsupportedImage || warning.Code == WarningToolLoopOverLimit {
+			return nil, nil, fmt.Errorf("invalid claude request: %s", warning.Message)
+		}
+	}
+	return out, warnings, nil
+}
+
+// ConversionStats summarizes a converted request for cost tracking. It is reported alongside
+// the converted body and never sent upstream.
+type ConversionStats struct {
+	// EstimatedInputTokens approximates the prompt size (system instruction, contents and
+	// tool declarations) at roughly four characters per token.
+	EstimatedInputTokens int64
+	// ToolDeclarations is the number of function declarations forwarded upstream.
+	ToolDeclarations int
+	// ThinkingEnabled reports whether a thinking configuration was attached.
+	ThinkingEnabled bool
+	// SystemCachedParts is the number of leading systemInstruction parts inside the client's
+	// cache_control prefix, i.e. up to the last annotated system block. 0 means no breakpoint.
+	SystemCachedParts int
+}
+
+// ConversionStatsFor returns the statistics of a conversion, derived from the Claude request
+// inputRawJSON and the body out it was converted to.
+func ConversionStatsFor(inputRawJSON, out []byte) ConversionStats {
+	stats := conversionStats(out)
+	stats.SystemCachedParts = systemCacheBoundary(gjson.GetBytes(inputRawJSON, "system"))
+	return stats
+}
+
+// ConvertClaudeRequestToAntigravityWithStats converts the request like
+// ConvertClaudeRequestToAntigravityWithOptions and also returns its ConversionStats. Callers that
+// change the body before sending it, such as the executor applying thinking from a model suffix,
+// should call ConversionStatsFor on the final body instead, which reads it only once.
+func ConvertClaudeRequestToAntigravityWithStats(modelName string, inputRawJSON []byte, _ bool, opts RequestOptions) ([]byte, ConversionStats) {
+	out, _ := convertClaudeRequest(modelName, inputRawJSON, opts)
+	return out, ConversionStatsFor(inputRawJSON, out)
+}
+
+// systemCacheBoundary counts the systemInstruction parts up to and including the last system
+// block carrying cache_control. Every text block becomes one part, in order, and hints are only
+// ever appended after them, so this prefix maps one-to-one onto the converted parts.
+func systemCacheBoundary(system gjson.Result) int {
+	if system.IsObject() {
+		if system.Get("type").String() == "text" && system.Get("cache_control").Exists() {
+			return 1
+		}
+		return 0
+	}
+	boundary, parts := 0, 0
+	for _, block := range system.Array() {
+		if block.Get("type").String() == "text" {
+			parts++
+		}
+		if block.Get("cache_control").Exists() {
+			boundary = parts
+		}
+	}
+	return boundary
+}
+
+func conversionStats(out []byte) ConversionStats {
+	request := gjson.GetBytes(out, "request")
+	stats := ConversionStats{
+		ToolDeclarations: len(request.Get("tools.0.functionDeclarations").Array()),
+		ThinkingEnabled:  request.Get("generationConfig.thinkingConfig").Exists(),
+	}
+
+	var chars int
+	countParts := func(parts gjson.Result) {
+		for _, part := range parts.Array() {
+			if text := part.Get("text"); text.Exists() {
+				chars += len(text.String())
+			}
+			if call := part.Get("functionCall"); call.Exists() {
+				chars += len(call.Raw)
+			}
+			if response := part.Get("functionResponse"); response.Exists() {
+				chars += len(response.Raw)
+			}
+		}
+	}
+	countParts(request.Get("systemInstruction.parts"))
+	for _, content := range request.Get("contents").Array() {
+		countParts(content.Get("parts"))
+	}
+	if tools := request.Get("tools"); tools.Exists() {
+		chars += len(tools.Raw)
+	}
+	stats.EstimatedInputTokens = int64((chars + 3) / 4)
+	return stats
+}
+
+// DeriveSessionID returns a stable session identifier for a Claude request so callers can
+// correlate logs and signature-cache behavior across turns. A "session_" marker in
+// metadata.user_id wins; otherwise the ID is a hash of the first user message text.
+// It returns an empty string when neither is available.
+func DeriveSessionID(rawJSON []byte) string {
+	if userID := gjson.GetBytes(rawJSON, "metadata.user_id").String(); userID != "" {
+		if idx := strings.LastIndex(userID, "session_"); idx >= 0 {
+			if sessionID := userID[idx+len("session_"):]; sessionID != "" {
+				return sessionID
+			}
+		}
+	}
+
+	for _, message := range gjson.GetBytes(rawJSON, "messages").Array() {
+		if message.Get("role").String() != "user" {
+			continue
+		}
+		text := ""
+		content := message.Get("content")
+		if content.Type == gjson.String {
+			text = content.String()
+		} else {
+			for _, block := range content.Array() {
+				if block.Get("type").String() == "text" {
+					text = block.Get("text").String()
+					break
+				}
+			}
+		}
+		if text != "" {
+			h := sha256.Sum256([]byte(text))
+			return hex.EncodeToString(h[:])[:16]
+		}
+		break
+	}
+	return ""
 }
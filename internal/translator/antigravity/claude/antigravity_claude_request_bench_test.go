@@ -0,0 +1,50 @@
+package claude
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildLargeClaudeRequest returns a Claude request with the given number of turns and tools.
+func buildLargeClaudeRequest(turns, tools int) []byte {
+	var messages []string
+	for i := 0; i < turns; i++ {
+		messages = append(messages,
+			fmt.Sprintf(`{"role":"user","content":[{"type":"text","text":"%s question %d"}]}`, strings.Repeat("lorem ipsum ", 50), i),
+			fmt.Sprintf(`{"role":"assistant","content":[{"type":"text","text":"answer %d"},{"type":"tool_use","id":"call-%d-1","name":"tool_%d","input":{"q":"x"}}]}`, i, i, i%tools),
+			fmt.Sprintf(`{"role":"user","content":[{"type":"tool_result","tool_use_id":"call-%d-1","content":"ok"}]}`, i),
+		)
+	}
+	var toolDefs []string
+	for i := 0; i < tools; i++ {
+		toolDefs = append(toolDefs, fmt.Sprintf(`{"name":"tool_%d","description":"tool %d","input_schema":{"type":"object","properties":{"q":{"type":"string","minLength":1},"n":{"type":["integer","null"]}},"required":["q"]}}`, i, i))
+	}
+	return []byte(`{"model":"claude-sonnet-4-5","max_tokens":1024,"system":"You are helpful.","messages":[` +
+		strings.Join(messages, ",") + `],"tools":[` + strings.Join(toolDefs, ",") + `]}`)
+}
+
+func BenchmarkConvertClaudeRequestToAntigravity(b *testing.B) {
+	for _, size := range []struct{ turns, tools int }{{10, 5}, {100, 20}} {
+		raw := buildLargeClaudeRequest(size.turns, size.tools)
+		b.Run(fmt.Sprintf("turns=%d/tools=%d", size.turns, size.tools), func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", raw, false)
+			}
+		})
+	}
+}
+
+func BenchmarkConvertClaudeRequestToAntigravity_LargeImage(b *testing.B) {
+	data := strings.Repeat("iVBORw0KGgoAAAANSUhEUgAA", 20<<20/24)
+	raw := []byte(`{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":[` +
+		`{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + data + `"}},` +
+		`{"type":"text","text":"Describe this image"}]}]}`)
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", raw, false)
+	}
+}
@@ -0,0 +1,56 @@
+package claude
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// FuzzConvertClaudeRequestToAntigravity feeds arbitrary client bodies to the converter, seeded
+// with the captured requests in testdata. Malformed or non-object input must be rejected with an error,
+// as may images of an unsupported media type; anything else must convert without panicking into a
+// well-formed Antigravity envelope.
+func FuzzConvertClaudeRequestToAntigravity(f *testing.F) {
+	requests, err := filepath.Glob(filepath.Join("testdata", "*.request.json"))
+	if err != nil {
+		f.Fatalf("glob testdata: %v", err)
+	}
+	for _, requestPath := range requests {
+		seed, errRead := os.ReadFile(requestPath)
+		if errRead != nil {
+			f.Fatalf("read fixture: %v", errRead)
+		}
+		f.Add(seed)
+	}
+	f.Add([]byte(`{"messages":[{"role":"user","content":[null,1,"text",{"type":"tool_result"}]}]}`))
+	f.Add([]byte(`{"system":{"type":"text"},"tools":[{"input_schema":true}],"thinking":{"type":"enabled","budget_tokens":-1}}`))
+	f.Add([]byte(`[]`))
+
+	level := log.GetLevel()
+	log.SetLevel(log.ErrorLevel)
+	f.Cleanup(func() { log.SetLevel(level) })
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, _, errConvert := ConvertClaudeRequestToAntigravityE("claude-sonnet-4-5-thinking", input, false, RequestOptions{})
+		if errConvert != nil {
+			if gjson.ValidBytes(input) && gjson.ParseBytes(input).IsObject() && !strings.Contains(errConvert.Error(), "unsupported image media type") {
+				t.Fatalf("unexpected error for object input %q: %v", input, errConvert)
+			}
+			return
+		}
+		if !gjson.ValidBytes(output) {
+			t.Fatalf("converter produced invalid JSON\ninput:  %q\noutput: %s", input, output)
+		}
+		root := gjson.ParseBytes(output)
+		if root.Get("model").Type != gjson.String || !root.Get("request").IsObject() {
+			t.Fatalf("missing model or request envelope\ninput:  %q\noutput: %s", input, output)
+		}
+		if contents := root.Get("request.contents"); contents.Exists() && !contents.IsArray() {
+			t.Fatalf("request.contents is not an array\ninput:  %q\noutput: %s", input, output)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package claude
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	"github.com/tidwall/gjson"
+)
+
+// updateGolden regenerates testdata/*.golden.json from the current converter output:
+//
+//	go test ./internal/translator/antigravity/claude/ -run Golden -update
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// TestConvertClaudeRequestToAntigravity_Golden converts every captured (and sanitized)
+// client request in testdata/*.request.json and diffs the result against the matching
+// .golden.json file. Goldens are stored indented so regressions show up as readable diffs.
+func TestConvertClaudeRequestToAntigravity_Golden(t *testing.T) {
+	requests, err := filepath.Glob(filepath.Join("testdata", "*.request.json"))
+	if err != nil {
+		t.Fatalf("glob testdata: %v", err)
+	}
+	if len(requests) == 0 {
+		t.Fatal("no request fixtures found in testdata")
+	}
+
+	for _, requestPath := range requests {
+		name := strings.TrimSuffix(filepath.Base(requestPath), ".request.json")
+		t.Run(name, func(t *testing.T) {
+			util.ResetCaches()
+
+			input, errRead := os.ReadFile(requestPath)
+			if errRead != nil {
+				t.Fatalf("read fixture: %v", errRead)
+			}
+			model := gjson.GetBytes(input, "model").String()
+			output := ConvertClaudeRequestToAntigravity(model, input, gjson.GetBytes(input, "stream").Bool())
+
+			var got bytes.Buffer
+			if errIndent := json.Indent(&got, output, "", "  "); errIndent != nil {
+				t.Fatalf("converter produced invalid JSON: %v\n%s", errIndent, output)
+			}
+			got.WriteByte('\n')
+
+			goldenPath := filepath.Join("testdata", name+".golden.json")
+			if *updateGolden {
+				if errWrite := os.WriteFile(goldenPath, got.Bytes(), 0o644); errWrite != nil {
+					t.Fatalf("write golden: %v", errWrite)
+				}
+				return
+			}
+
+			want, errGolden := os.ReadFile(goldenPath)
+			if errGolden != nil {
+				t.Fatalf("read golden (run with -update to create it): %v", errGolden)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("output differs from %s (run with -update to accept)\n--- got ---\n%s\n--- want ---\n%s", goldenPath, got.Bytes(), want)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package claude
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 
@@ -73,6 +76,14 @@ func TestConvertClaudeRequestToAntigravity_RoleMapping(t *testing.T) {
 	}
 }
 
+// cacheSignature caches signature for text and clears the shared signature cache when the test
+// ends, so later tests do not see signatures they never cached.
+func cacheSignature(t *testing.T, model, text, signature string) {
+	t.Helper()
+	cache.CacheSignature(model, text, signature)
+	t.Cleanup(func() { cache.ClearSignatureCache("") })
+}
+
 func TestConvertClaudeRequestToAntigravity_ThinkingBlocks(t *testing.T) {
 	// Valid signature must be at least 50 characters
 	validSignature := "abc123validSignature1234567890123456789012345678901234567890"
@@ -98,7 +109,7 @@ func TestConvertClaudeRequestToAntigravity_ThinkingBlocks(t *testing.T) {
 		]
 	}`)
 
-	cache.CacheSignature("claude-sonnet-4-5-thinking", thinkingText, validSignature)
+	cacheSignature(t, "claude-sonnet-4-5-thinking", thinkingText, validSignature)
 
 	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
 	outputStr := string(output)
@@ -263,7 +274,7 @@ func TestConvertClaudeRequestToAntigravity_ToolUse_WithSignature(t *testing.T) {
 		]
 	}`)
 
-	cache.CacheSignature("claude-sonnet-4-5-thinking", thinkingText, validSignature)
+	cacheSignature(t, "claude-sonnet-4-5-thinking", thinkingText, validSignature)
 
 	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
 	outputStr := string(output)
@@ -300,7 +311,7 @@ func TestConvertClaudeRequestToAntigravity_ReorderThinking(t *testing.T) {
 		]
 	}`)
 
-	cache.CacheSignature("claude-sonnet-4-5-thinking", thinkingText, validSignature)
+	cacheSignature(t, "claude-sonnet-4-5-thinking", thinkingText, validSignature)
 
 	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
 	outputStr := string(output)
@@ -323,6 +334,17 @@ func TestConvertClaudeRequestToAntigravity_ToolResult(t *testing.T) {
 	inputJSON := []byte(`{
 		"model": "claude-3-5-sonnet-20240620",
 		"messages": [
+			{
+				"role": "assistant",
+				"content": [
+					{
+						"type": "tool_use",
+						"id": "get_weather-call-123",
+						"name": "get_weather",
+						"input": {"location": "Paris"}
+					}
+				]
+			},
 			{
 				"role": "user",
 				"content": [
@@ -340,7 +362,7 @@ func TestConvertClaudeRequestToAntigravity_ToolResult(t *testing.T) {
 	outputStr := string(output)
 
 	// Check function response conversion
-	funcResp := gjson.Get(outputStr, "request.contents.0.parts.0.functionResponse")
+	funcResp := gjson.Get(outputStr, "request.contents.1.parts.0.functionResponse")
 	if !funcResp.Exists() {
 		t.Error("functionResponse should exist")
 	}
@@ -508,7 +530,7 @@ func TestConvertClaudeRequestToAntigravity_TrailingSignedThinking_Kept(t *testin
 		]
 	}`)
 
-	cache.CacheSignature("claude-sonnet-4-5-thinking", thinkingText, validSignature)
+	cacheSignature(t, "claude-sonnet-4-5-thinking", thinkingText, validSignature)
 
 	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
 	outputStr := string(output)
@@ -689,3 +711,1686 @@ func TestConvertClaudeRequestToAntigravity_ToolAndThinking_NoExistingSystem(t *t
 		t.Errorf("Interleaved thinking hint should be in created systemInstruction, got: %v", sysInstruction.Raw)
 	}
 }
+
+func TestConvertClaudeRequestToAntigravity_ToolResponseSchemaSanitized(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}],
+		"tools": [
+			{
+				"name": "get_weather",
+				"description": "Get weather",
+				"input_schema": {"type": "object", "properties": {"location": {"type": "string"}}, "required": ["location"]},
+				"responseJsonSchema": {
+					"$schema": "http://json-schema.org/draft-07/schema#",
+					"type": "object",
+					"properties": {"temp": {"type": "number", "format": "float"}},
+					"required": ["temp"],
+					"additionalProperties": false
+				}
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	outputStr := string(output)
+
+	responseSchema := gjson.Get(outputStr, "request.tools.0.functionDeclarations.0.responseJsonSchema")
+	if !responseSchema.Exists() {
+		t.Fatal("responseJsonSchema should be preserved")
+	}
+	if responseSchema.Get("$schema").Exists() || responseSchema.Get("additionalProperties").Exists() {
+		t.Errorf("responseJsonSchema should be sanitized, got: %s", responseSchema.Raw)
+	}
+	if responseSchema.Get("properties.temp.format").Exists() {
+		t.Errorf("Unsupported constraints should be removed from response schema, got: %s", responseSchema.Raw)
+	}
+	if responseSchema.Get("properties.temp.type").String() != "number" {
+		t.Errorf("Response schema properties should be kept, got: %s", responseSchema.Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_EmptyMessages(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"messages": [],
+		"system": [{"type": "text", "text": "You are helpful."}],
+		"tools": [
+			{
+				"name": "get_weather",
+				"description": "Get weather",
+				"input_schema": {"type": "object", "properties": {"location": {"type": "string"}}}
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	outputStr := string(output)
+
+	contents := gjson.Get(outputStr, "request.contents").Array()
+	if len(contents) != 1 {
+		t.Fatalf("Expected 1 injected content, got %d: %s", len(contents), outputStr)
+	}
+	if contents[0].Get("role").String() != "user" {
+		t.Errorf("Expected injected turn role 'user', got '%s'", contents[0].Get("role").String())
+	}
+	if contents[0].Get("parts.0.text").String() == "" {
+		t.Error("Injected turn should carry non-empty text")
+	}
+	if !gjson.Get(outputStr, "request.systemInstruction").Exists() {
+		t.Error("systemInstruction should be preserved")
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ToolsOnlyProbe(t *testing.T) {
+	// No messages key at all, only tools and a tool_choice.
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"tool_choice": {"type": "any"},
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"location": {"type": "string"}}}}]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	contents := gjson.GetBytes(output, "request.contents").Array()
+	if len(contents) != 1 || contents[0].Get("role").String() != "user" || contents[0].Get("parts.0.text").String() != emptyMessagesPlaceholder {
+		t.Fatalf("Expected a single synthesized user turn, got %s", gjson.GetBytes(output, "request.contents").Raw)
+	}
+	if name := gjson.GetBytes(output, "request.tools.0.functionDeclarations.0.name").String(); name != "get_weather" {
+		t.Errorf("Expected tools to be attached, got %s", gjson.GetBytes(output, "request.tools").Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ThinkingOnlyAssistantTurn(t *testing.T) {
+	cache.ClearSignatureCache("")
+
+	validSignature := "abc123validSignature1234567890123456789012345678901234567890"
+	thinkingText := "Only thinking in this turn"
+	cacheSignature(t, "claude-sonnet-4-5-thinking", thinkingText, validSignature)
+
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5-thinking",
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Hi"}]},
+			{
+				"role": "assistant",
+				"content": [
+					{"type": "thinking", "thinking": "` + thinkingText + `", "signature": "` + validSignature + `"}
+				]
+			},
+			{"role": "user", "content": [{"type": "text", "text": "Go on"}]}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
+	outputStr := string(output)
+
+	parts := gjson.Get(outputStr, "request.contents.1.parts").Array()
+	if len(parts) != 2 {
+		t.Fatalf("Expected thought part plus text part, got %d: %s", len(parts), outputStr)
+	}
+	if !parts[0].Get("thought").Bool() {
+		t.Error("First part should remain the thought part")
+	}
+	if parts[1].Get("thought").Bool() || !parts[1].Get("text").Exists() {
+		t.Errorf("Second part should be a non-thought text part, got: %s", parts[1].Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ToolOrderPreserved(t *testing.T) {
+	names := []string{"zeta_tool", "alpha_tool", "mid_tool", "beta_tool"}
+	var tools []string
+	for _, name := range names {
+		tools = append(tools, `{"name":"`+name+`","description":"d","input_schema":{"type":"object","properties":{"q":{"type":"string"}}}}`)
+	}
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}],
+		"tools": [` + strings.Join(tools, ",") + `]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	decls := gjson.GetBytes(output, "request.tools.0.functionDeclarations").Array()
+	if len(decls) != len(names) {
+		t.Fatalf("Expected %d declarations, got %d", len(names), len(decls))
+	}
+	for i, name := range names {
+		if got := decls[i].Get("name").String(); got != name {
+			t.Errorf("Declaration %d: expected %s, got %s", i, name, got)
+		}
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_FractionalTopK(t *testing.T) {
+	tests := []struct {
+		name     string
+		topK     string
+		expected string
+	}{
+		{"round down", "40.3", "40"},
+		{"round up", "40.7", "41"},
+		{"clamp low", "0.2", "1"},
+		{"clamp negative", "-5", "1"},
+		{"clamp high", "100000", "500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputJSON := []byte(`{
+				"model": "claude-sonnet-4-5",
+				"messages": [{"role": "user", "content": "Hi"}],
+				"top_k": ` + tt.topK + `
+			}`)
+
+			output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+			topK := gjson.GetBytes(output, "request.generationConfig.topK")
+			if topK.Raw != tt.expected {
+				t.Errorf("Expected integer topK %s, got %s", tt.expected, topK.Raw)
+			}
+		})
+	}
+}
+
+type recordingSanitizer struct {
+	calls []string
+}
+
+func (r *recordingSanitizer) Clean(jsonStr string) string {
+	r.calls = append(r.calls, jsonStr)
+	return `{"type":"object","properties":{"stubbed":{"type":"string"}}}`
+}
+
+func TestConvertClaudeRequestToAntigravity_CustomSchemaSanitizer(t *testing.T) {
+	stub := &recordingSanitizer{}
+	SetSchemaSanitizer(stub)
+	t.Cleanup(func() { SetSchemaSanitizer(nil) })
+
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}],
+		"tools": [
+			{"name": "a", "input_schema": {"type": "object", "properties": {"x": {"type": "string"}}}},
+			{"name": "b", "input_schema": {"type": "object", "properties": {"y": {"type": "number"}}}}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	if len(stub.calls) != 2 {
+		t.Fatalf("Expected sanitizer to be called twice, got %d", len(stub.calls))
+	}
+	if !strings.Contains(stub.calls[0], `"x"`) || !strings.Contains(stub.calls[1], `"y"`) {
+		t.Errorf("Sanitizer received unexpected schemas: %v", stub.calls)
+	}
+	if !gjson.GetBytes(output, "request.tools.0.functionDeclarations.0.parametersJsonSchema.properties.stubbed").Exists() {
+		t.Errorf("Expected stub sanitizer output in declaration, got: %s", output)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_SystemInstructionRole(t *testing.T) {
+	SetRoleLessSystemInstructionModels([]string{"gemini-3-*", "claude-opus-4-5"})
+	t.Cleanup(func() { SetRoleLessSystemInstructionModels(nil) })
+
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}],
+		"system": "You are helpful."
+	}`)
+
+	tests := []struct {
+		model    string
+		wantRole bool
+	}{
+		{"claude-sonnet-4-5", true},
+		{"claude-opus-4-5", false},
+		{"gemini-3-pro-preview", false},
+		{"gemini-2.5-flash", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			output := ConvertClaudeRequestToAntigravity(tt.model, inputJSON, false)
+			sys := gjson.GetBytes(output, "request.systemInstruction")
+			if sys.Get("parts.0.text").String() != "You are helpful." {
+				t.Fatalf("systemInstruction text mismatch: %s", sys.Raw)
+			}
+			role := sys.Get("role")
+			if tt.wantRole && role.String() != "user" {
+				t.Errorf("Expected role 'user', got %s", sys.Raw)
+			}
+			if !tt.wantRole && role.Exists() {
+				t.Errorf("Expected no role, got %s", sys.Raw)
+			}
+		})
+	}
+}
+
+func TestDeriveSessionID(t *testing.T) {
+	fromMetadata := DeriveSessionID([]byte(`{
+		"metadata": {"user_id": "user_abc_account__session_1234-5678"},
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`))
+	if fromMetadata != "1234-5678" {
+		t.Errorf("Expected session from metadata, got %q", fromMetadata)
+	}
+
+	fromString := DeriveSessionID([]byte(`{"messages": [{"role": "user", "content": "Hello"}]}`))
+	fromBlocks := DeriveSessionID([]byte(`{"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}]}`))
+	if fromString == "" || fromString != fromBlocks {
+		t.Errorf("Expected identical hash-based session IDs, got %q and %q", fromString, fromBlocks)
+	}
+	if len(fromString) != 16 {
+		t.Errorf("Expected 16-char session ID, got %q", fromString)
+	}
+
+	other := DeriveSessionID([]byte(`{"messages": [{"role": "user", "content": "Different"}]}`))
+	if other == fromString {
+		t.Error("Different first messages should derive different session IDs")
+	}
+
+	if got := DeriveSessionID([]byte(`{"messages": []}`)); got != "" {
+		t.Errorf("Expected empty session ID without user messages, got %q", got)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_OrphanToolResultDropped(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Weather?"}]},
+			{
+				"role": "assistant",
+				"content": [
+					{"type": "tool_use", "id": "toolu_known", "name": "get_weather", "input": {"location": "Paris"}}
+				]
+			},
+			{
+				"role": "user",
+				"content": [
+					{"type": "tool_result", "tool_use_id": "toolu_known", "content": "22C"},
+					{"type": "tool_result", "tool_use_id": "toolu_orphan", "content": "stale"}
+				]
+			},
+			{
+				"role": "user",
+				"content": [
+					{"type": "tool_result", "tool_use_id": "toolu_other_orphan", "content": "stale"}
+				]
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	outputStr := string(output)
+
+	contents := gjson.Get(outputStr, "request.contents").Array()
+	if len(contents) != 3 {
+		t.Fatalf("Expected 3 contents (orphan-only turn removed), got %d: %s", len(contents), outputStr)
+	}
+	parts := contents[2].Get("parts").Array()
+	if len(parts) != 1 {
+		t.Fatalf("Expected orphan tool_result to be dropped, got %d parts", len(parts))
+	}
+	funcResp := parts[0].Get("functionResponse")
+	if funcResp.Get("id").String() != "toolu_known" {
+		t.Errorf("Expected matched functionResponse, got %s", funcResp.Raw)
+	}
+	if funcResp.Get("name").String() != "get_weather" {
+		t.Errorf("Expected name from matching tool_use, got %s", funcResp.Get("name").String())
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_Base64URLImage(t *testing.T) {
+	// Raw bytes 0xfb 0xff 0xbf encode to "-_-_" in base64url but "+/+/" in standard base64.
+	inputJSON := []byte(`{
+		"model": "claude-3-5-sonnet-20240620",
+		"messages": [
+			{
+				"role": "user",
+				"content": [
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "-_-_aGk"}}
+				]
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	data := gjson.GetBytes(output, "request.contents.0.parts.0.inlineData.data").String()
+	if data != "+/+/aGk=" {
+		t.Errorf("Expected base64url data normalized to standard base64, got %q", data)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_UnsupportedImageMediaType(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-3-5-sonnet-20240620",
+		"messages": [
+			{
+				"role": "user",
+				"content": [
+					{"type": "image", "source": {"type": "base64", "media_type": "image/bmp", "data": "Qk0="}},
+					{"type": "image", "source": {"type": "base64", "media_type": "IMAGE/PNG", "data": "iVBORw0KGgo="}}
+				]
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	outputStr := string(output)
+
+	parts := gjson.Get(outputStr, "request.contents.0.parts").Array()
+	if len(parts) != 1 {
+		t.Fatalf("Expected only the supported image to remain, got %s", gjson.Get(outputStr, "request.contents.0.parts").Raw)
+	}
+	if parts[0].Get("inlineData.data").String() != "iVBORw0KGgo=" {
+		t.Error("Expected supported image to be kept regardless of case")
+	}
+	if strings.Contains(outputStr, "image/bmp") {
+		t.Errorf("Expected no trace of the unsupported image, got %s", outputStr)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MaxToolDeclarations(t *testing.T) {
+	SetMaxToolDeclarations(2)
+	t.Cleanup(func() { SetMaxToolDeclarations(0) })
+
+	inputJSON := []byte(`{
+		"model": "claude-3-5-sonnet-20240620",
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi"}]}],
+		"tools": [
+			{"name": "first", "input_schema": {"type": "object"}},
+			{"name": "second", "input_schema": {"type": "object"}},
+			{"name": "third", "input_schema": {"type": "object"}}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	decls := gjson.GetBytes(output, "request.tools.0.functionDeclarations").Array()
+	if len(decls) != 2 {
+		t.Fatalf("Expected 2 function declarations after cap, got %d", len(decls))
+	}
+	if decls[0].Get("name").String() != "first" || decls[1].Get("name").String() != "second" {
+		t.Errorf("Expected the first tools to be kept in order, got %s", gjson.GetBytes(output, "request.tools").Raw)
+	}
+
+	SetMaxToolDeclarations(0)
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	if n := len(gjson.GetBytes(output, "request.tools.0.functionDeclarations").Array()); n != 3 {
+		t.Errorf("Expected all 3 tools without a cap, got %d", n)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MixedSignedAndUnsignedThinking(t *testing.T) {
+	validSignature := "abc123validSignature1234567890123456789012345678901234567890"
+	signedText := "Mixed signing test: signed reasoning"
+	cacheSignature(t, "claude-sonnet-4-5-thinking", signedText, validSignature)
+
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5-thinking",
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Weather?"}]},
+			{
+				"role": "assistant",
+				"content": [
+					{"type": "thinking", "thinking": "Mixed signing test: unsigned reasoning"},
+					{"type": "thinking", "thinking": "` + signedText + `"},
+					{"type": "tool_use", "id": "toolu_mixed", "name": "get_weather", "input": {}}
+				]
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
+	outputStr := string(output)
+
+	parts := gjson.Get(outputStr, "request.contents.1.parts").Array()
+	if len(parts) != 2 {
+		t.Fatalf("Expected signed thinking and tool call to remain, got %d parts: %s", len(parts), outputStr)
+	}
+	if !parts[0].Get("thought").Bool() || parts[0].Get("text").String() != signedText {
+		t.Errorf("Expected signed thinking block first, got %s", parts[0].Raw)
+	}
+	if got := parts[1].Get("thoughtSignature").String(); got != validSignature {
+		t.Errorf("Expected tool call to keep the thinking signature, got %q", got)
+	}
+	if !gjson.Get(outputStr, "request.generationConfig.thinkingConfig").Exists() {
+		t.Error("Expected thinkingConfig to survive a single unsigned block")
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithOptions_InterleavedThinkingBeta(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi"}]}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}]
+	}`)
+
+	// The model name alone does not trigger the interleaved thinking hint
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	if strings.Contains(string(output), "Interleaved thinking is enabled") {
+		t.Fatal("Expected no interleaved thinking hint without the beta flag")
+	}
+
+	headers := http.Header{}
+	headers.Add("Anthropic-Beta", "token-efficient-tools-2025-02-19, interleaved-thinking-2025-05-14")
+	opts := RequestOptions{AnthropicBetas: AnthropicBetasFromHeaders(headers)}
+	if len(opts.AnthropicBetas) != 2 {
+		t.Fatalf("Expected 2 beta flags, got %v", opts.AnthropicBetas)
+	}
+
+	output = ConvertClaudeRequestToAntigravityWithOptions("claude-sonnet-4-5", inputJSON, false, opts)
+	hint := gjson.GetBytes(output, "request.systemInstruction.parts.#.text").String()
+	if !strings.Contains(hint, "Interleaved thinking is enabled") {
+		t.Errorf("Expected interleaved-thinking beta to force the hint, got %s", gjson.GetBytes(output, "request.systemInstruction").Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ThinkingDisabled(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-opus-4-5-thinking",
+		"thinking": {"type": "disabled", "budget_tokens": 4000},
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi"}]}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}]
+	}`)
+
+	opts := RequestOptions{AnthropicBetas: []string{"interleaved-thinking-2025-05-14"}}
+	output := ConvertClaudeRequestToAntigravityWithOptions("claude-opus-4-5-thinking", inputJSON, false, opts)
+
+	if gjson.GetBytes(output, "request.generationConfig.thinkingConfig").Exists() {
+		t.Errorf("Expected no thinkingConfig when thinking is disabled, got %s", gjson.GetBytes(output, "request.generationConfig").Raw)
+	}
+	if strings.Contains(string(output), "Interleaved thinking is enabled") {
+		t.Error("Expected no interleaved thinking hint when thinking is disabled")
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_InterleavedThinkingHintModes(t *testing.T) {
+	defer SetInterleavedThinkingHint("")
+
+	withTools := []byte(`{
+		"model": "claude-opus-4-5-thinking",
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi"}]}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}]
+	}`)
+	withoutTools := []byte(`{
+		"model": "claude-opus-4-5-thinking",
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi"}]}]
+	}`)
+
+	tests := []struct {
+		mode                  string
+		wantTools, wantNoTool bool
+	}{
+		{mode: "", wantTools: true, wantNoTool: false},
+		{mode: InterleavedHintTools, wantTools: true, wantNoTool: false},
+		{mode: InterleavedHintAlways, wantTools: true, wantNoTool: true},
+		{mode: InterleavedHintNever, wantTools: false, wantNoTool: false},
+	}
+	for _, tt := range tests {
+		SetInterleavedThinkingHint(tt.mode)
+		for _, c := range []struct {
+			input []byte
+			want  bool
+		}{{withTools, tt.wantTools}, {withoutTools, tt.wantNoTool}} {
+			output := ConvertClaudeRequestToAntigravity("claude-opus-4-5-thinking", c.input, false)
+			if got := strings.Contains(string(output), "Interleaved thinking is enabled"); got != c.want {
+				t.Errorf("mode %q, tools=%v: expected hint=%v, got %v", tt.mode, gjson.GetBytes(c.input, "tools").Exists(), c.want, got)
+			}
+		}
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_WhitespaceOnlyTextDropped(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-3-5-sonnet-20240620",
+		"messages": [
+			{
+				"role": "user",
+				"content": [
+					{"type": "text", "text": "   "},
+					{"type": "text", "text": "  keep my indentation\n"},
+					{"type": "text", "text": "\n\t"}
+				]
+			},
+			{"role": "assistant", "content": "  \n "},
+			{"role": "user", "content": [{"type": "text", "text": " "}]}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	outputStr := string(output)
+
+	contents := gjson.Get(outputStr, "request.contents").Array()
+	if len(contents) != 1 {
+		t.Fatalf("Expected whitespace-only turns to be dropped, got %d contents: %s", len(contents), outputStr)
+	}
+	parts := contents[0].Get("parts").Array()
+	if len(parts) != 1 {
+		t.Fatalf("Expected 1 part, got %d: %s", len(parts), outputStr)
+	}
+	if parts[0].Get("text").String() != "  keep my indentation\n" {
+		t.Errorf("Expected real text preserved verbatim, got %q", parts[0].Get("text").String())
+	}
+}
+
+func TestConversionStatsFor(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"system": "You are a helpful assistant.",
+		"thinking": {"type": "enabled", "budget_tokens": 2048},
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "What is the weather in Paris today?"}]}],
+		"tools": [
+			{"name": "get_weather", "input_schema": {"type": "object"}},
+			{"name": "get_time", "input_schema": {"type": "object"}}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	stats := ConversionStatsFor(inputJSON, output)
+
+	if stats.ToolDeclarations != 2 {
+		t.Errorf("Expected 2 tool declarations, got %d", stats.ToolDeclarations)
+	}
+	if !stats.ThinkingEnabled {
+		t.Error("Expected thinking to be reported as enabled")
+	}
+	if stats.EstimatedInputTokens <= 0 {
+		t.Errorf("Expected a positive input token estimate, got %d", stats.EstimatedInputTokens)
+	}
+
+	smallJSON := []byte(`{"messages":[{"role":"user","content":"Hi"}]}`)
+	small := ConversionStatsFor(smallJSON, ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", smallJSON, false))
+	if small.EstimatedInputTokens >= stats.EstimatedInputTokens || small.ToolDeclarations != 0 || small.ThinkingEnabled {
+		t.Errorf("Expected smaller stats for a minimal request, got %+v", small)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithStats(t *testing.T) {
+	inputJSON := []byte(`{
+		"thinking": {"type": "enabled", "budget_tokens": 2048},
+		"messages": [{"role": "user", "content": "Check the forecast"}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}]
+	}`)
+
+	output, stats := ConvertClaudeRequestToAntigravityWithStats("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if plain := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false); string(output) != string(plain) {
+		t.Errorf("Expected the same body as the plain conversion, got %s", output)
+	}
+	if stats != ConversionStatsFor(inputJSON, output) {
+		t.Errorf("Expected stats of the returned body, got %+v", stats)
+	}
+	if stats.ToolDeclarations != 1 || !stats.ThinkingEnabled || stats.EstimatedInputTokens <= 0 {
+		t.Errorf("Expected stats to reflect the input, got %+v", stats)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MixedSystemCacheControl(t *testing.T) {
+	inputJSON := []byte(`{
+		"system": [
+			{"type": "text", "text": "Identity"},
+			{"type": "text", "text": "Long cached guidelines", "cache_control": {"type": "ephemeral"}},
+			{"type": "text", "text": "Per-request context"}
+		],
+		"messages": [{"role": "user", "content": "Hi"}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"parallel_tool_calls": false
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	stats := ConversionStatsFor(inputJSON, output)
+
+	parts := gjson.GetBytes(output, "request.systemInstruction.parts").Array()
+	wantPrefix := []string{"Identity", "Long cached guidelines", "Per-request context"}
+	if len(parts) != len(wantPrefix)+1 {
+		t.Fatalf("Expected system blocks plus one hint, got %s", gjson.GetBytes(output, "request.systemInstruction").Raw)
+	}
+	for i, want := range wantPrefix {
+		if got := parts[i].Get("text").String(); got != want {
+			t.Errorf("part %d: expected %q, got %q", i, want, got)
+		}
+		if parts[i].Get("cache_control").Exists() {
+			t.Errorf("part %d: cache_control must not be forwarded", i)
+		}
+	}
+	if stats.SystemCachedParts != 2 {
+		t.Errorf("Expected cache boundary after the second part, got %d", stats.SystemCachedParts)
+	}
+
+	plainJSON := []byte(`{"system":"Hi","messages":[{"role":"user","content":"Hi"}]}`)
+	plain := ConversionStatsFor(plainJSON, ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", plainJSON, false))
+	if plain.SystemCachedParts != 0 {
+		t.Errorf("Expected no cache boundary without cache_control, got %d", plain.SystemCachedParts)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityE_MalformedJSON(t *testing.T) {
+	_, _, err := ConvertClaudeRequestToAntigravityE("claude-sonnet-4-5", []byte(`{"messages": [`), false, RequestOptions{})
+	if err == nil {
+		t.Fatal("Expected an error for malformed JSON")
+	}
+	if !strings.Contains(err.Error(), "invalid claude request JSON") {
+		t.Errorf("Expected parse detail in error, got %v", err)
+	}
+
+	if _, _, err = ConvertClaudeRequestToAntigravityE("claude-sonnet-4-5", []byte(`[1, 2]`), false, RequestOptions{}); err == nil {
+		t.Error("Expected an error for a non-object body")
+	}
+
+	valid := []byte(`{"messages":[{"role":"user","content":"Hi"}]}`)
+	out, _, err := ConvertClaudeRequestToAntigravityE("claude-sonnet-4-5", valid, false, RequestOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error for valid JSON: %v", err)
+	}
+	if string(out) != string(ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", valid, false)) {
+		t.Error("Expected the same output as ConvertClaudeRequestToAntigravity for valid JSON")
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityE_UnsupportedImage(t *testing.T) {
+	inputJSON := []byte(`{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/bmp","data":"Qk0="}}]}]}`)
+
+	_, _, err := ConvertClaudeRequestToAntigravityE("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if err == nil {
+		t.Fatal("Expected an error for an unsupported image media type")
+	}
+	if !strings.Contains(err.Error(), "image/bmp") || !strings.Contains(err.Error(), "image/png") {
+		t.Errorf("Expected the error to name the rejected and supported types, got %v", err)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_CachedSystemPrefixKeptBeforeHint(t *testing.T) {
+	cachedPrefix := "Long, stable system prompt that clients mark as cacheable."
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5-thinking",
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"system": [
+			{"type": "text", "text": "` + cachedPrefix + `", "cache_control": {"type": "ephemeral"}},
+			{"type": "text", "text": "Per-request note."}
+		],
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi"}]}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
+
+	parts := gjson.GetBytes(output, "request.systemInstruction.parts").Array()
+	if len(parts) != 3 {
+		t.Fatalf("Expected cached prefix, note and hint as separate parts, got %d: %s", len(parts), gjson.GetBytes(output, "request.systemInstruction").Raw)
+	}
+	if parts[0].Get("text").String() != cachedPrefix {
+		t.Errorf("Expected cached prefix to stay untouched, got %q", parts[0].Get("text").String())
+	}
+	if !strings.HasPrefix(parts[2].Get("text").String(), "Interleaved thinking is enabled") {
+		t.Errorf("Expected hint appended after the cached prefix, got %q", parts[2].Get("text").String())
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ToolResultWithImage(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-3-5-sonnet-20240620",
+		"messages": [
+			{
+				"role": "assistant",
+				"content": [{"type": "tool_use", "id": "toolu_shot", "name": "screenshot", "input": {}}]
+			},
+			{
+				"role": "user",
+				"content": [
+					{
+						"type": "tool_result",
+						"tool_use_id": "toolu_shot",
+						"content": [
+							{"type": "text", "text": "Captured the window."},
+							{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+						]
+					}
+				]
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	outputStr := string(output)
+
+	parts := gjson.Get(outputStr, "request.contents.1.parts").Array()
+	if len(parts) != 2 {
+		t.Fatalf("Expected functionResponse plus inline image, got %d parts: %s", len(parts), outputStr)
+	}
+	if got := parts[0].Get("functionResponse.response.result").String(); got != "Captured the window." {
+		t.Errorf("Expected text blocks as the result, got %q", got)
+	}
+	if parts[1].Get("inlineData.mime_type").String() != "image/png" || parts[1].Get("inlineData.data").String() != "iVBORw0KGgo=" {
+		t.Errorf("Expected image inlined after the functionResponse, got %s", parts[1].Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_EmptyTextBeforeToolUse(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-3-5-sonnet-20240620",
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Weather?"}]},
+			{
+				"role": "assistant",
+				"content": [
+					{"type": "text", "text": ""},
+					{"type": "tool_use", "id": "toolu_empty", "name": "get_weather", "input": {"location": "Paris"}}
+				]
+			}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	outputStr := string(output)
+
+	parts := gjson.Get(outputStr, "request.contents.1.parts").Array()
+	if len(parts) != 1 {
+		t.Fatalf("Expected empty text part to be dropped, got %d parts: %s", len(parts), outputStr)
+	}
+	if parts[0].Get("functionCall.name").String() != "get_weather" {
+		t.Errorf("Expected only the function call to remain, got %s", parts[0].Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ThinkingWithoutBudgetNoEmptyConfig(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5-thinking",
+		"thinking": {"type": "enabled"},
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi"}]}]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
+
+	if genConfig := gjson.GetBytes(output, "request.generationConfig"); genConfig.Exists() {
+		t.Errorf("Expected no generationConfig when no field is set, got %s", genConfig.Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_DefaultMaxOutputTokens(t *testing.T) {
+	SetDefaultMaxOutputTokens(8192)
+	t.Cleanup(func() { SetDefaultMaxOutputTokens(0) })
+
+	omitted := []byte(`{"messages":[{"role":"user","content":"Hi"}]}`)
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", omitted, false)
+	if got := gjson.GetBytes(output, "request.generationConfig.maxOutputTokens").Int(); got != 8192 {
+		t.Errorf("Expected default maxOutputTokens 8192 when max_tokens is omitted, got %d", got)
+	}
+
+	explicit := []byte(`{"max_tokens":1024,"messages":[{"role":"user","content":"Hi"}]}`)
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", explicit, false)
+	if got := gjson.GetBytes(output, "request.generationConfig.maxOutputTokens").Int(); got != 1024 {
+		t.Errorf("Expected explicit max_tokens to win, got %d", got)
+	}
+
+	SetDefaultMaxOutputTokens(0)
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", omitted, false)
+	if gjson.GetBytes(output, "request.generationConfig.maxOutputTokens").Exists() {
+		t.Error("Expected no maxOutputTokens without a configured default")
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_SanitizeTextParts(t *testing.T) {
+	inputJSON := []byte("{\"messages\":[{\"role\":\"user\",\"content\":[{\"type\":\"text\",\"text\":\"hello\xff\xfe \\u0007world\\n\"}]}]}")
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	if got := gjson.GetBytes(output, "request.contents.0.parts.0.text").String(); !strings.Contains(got, "\a") {
+		t.Errorf("Expected text untouched when sanitizing is off, got %q", got)
+	}
+
+	SetSanitizeTextParts(true)
+	t.Cleanup(func() { SetSanitizeTextParts(false) })
+
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	if got := gjson.GetBytes(output, "request.contents.0.parts.0.text").String(); got != "hello world\n" {
+		t.Errorf("Expected invalid UTF-8 and control characters stripped, got %q", got)
+	}
+
+	toolResultJSON := []byte("{\"messages\":[" +
+		"{\"role\":\"assistant\",\"content\":[{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"Read\",\"input\":{}}]}," +
+		"{\"role\":\"user\",\"content\":[{\"type\":\"tool_result\",\"tool_use_id\":\"toolu_1\",\"content\":\"ok\xff \\u0007\uFFFD\"}]}]}")
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", toolResultJSON, false)
+	if got := gjson.GetBytes(output, "request.contents.1.parts.0.functionResponse.response.result").String(); got != "ok \uFFFD" {
+		t.Errorf("Expected tool_result sanitized with a real U+FFFD kept, got %q", got)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_SystemAsSingleObject(t *testing.T) {
+	inputJSON := []byte(`{
+		"system": {"type": "text", "text": "You are terse."},
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	parts := gjson.GetBytes(output, "request.systemInstruction.parts").Array()
+	if len(parts) != 1 || parts[0].Get("text").String() != "You are terse." {
+		t.Errorf("Expected object-form system prompt to be kept, got %s", gjson.GetBytes(output, "request.systemInstruction").Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_RequestSafetySettings(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": "Hi"}],
+		"safety_settings": [{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_MEDIUM_AND_ABOVE"}]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	settings := gjson.GetBytes(output, "request.safetySettings")
+	if got := settings.Get(`#(category=="HARM_CATEGORY_DANGEROUS_CONTENT").threshold`).String(); got != "BLOCK_MEDIUM_AND_ABOVE" {
+		t.Errorf("Expected request safety threshold to override the default, got %s", settings.Raw)
+	}
+	if got := settings.Get(`#(category=="HARM_CATEGORY_HARASSMENT").threshold`).String(); got != "OFF" {
+		t.Errorf("Expected other categories to keep the default, got %s", got)
+	}
+
+	invalid := []byte(`{"messages":[{"role":"user","content":"Hi"}],"safety_settings":[{"category":"HARM_CATEGORY_BOGUS","threshold":"OFF"}]}`)
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", invalid, false)
+	if n := len(gjson.GetBytes(output, "request.safetySettings").Array()); n != 5 {
+		t.Errorf("Expected invalid request settings to fall back to defaults, got %d settings", n)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ToolSchemaRootDefs(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": "Hi"}],
+		"$defs": {"Location": {"type": "object", "properties": {"lat": {"type": "number"}, "lng": {"type": "number"}}, "required": ["lat", "lng"]}},
+		"tools": [
+			{"name": "weather", "input_schema": {"type": "object", "properties": {"where": {"$ref": "#/$defs/Location"}}}},
+			{"name": "route", "input_schema": {"type": "object", "properties": {"from": {"$ref": "#/$defs/Location"}, "to": {"$ref": "#/$defs/Location"}}}}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	decls := gjson.GetBytes(output, "request.tools.0.functionDeclarations").Array()
+	if len(decls) != 2 {
+		t.Fatalf("Expected 2 declarations, got %d", len(decls))
+	}
+	for _, path := range []string{"0.parametersJsonSchema.properties.where", "1.parametersJsonSchema.properties.from", "1.parametersJsonSchema.properties.to"} {
+		node := gjson.GetBytes(output, "request.tools.0.functionDeclarations."+path)
+		if node.Get("properties.lat.type").String() != "number" {
+			t.Errorf("Expected %s to inline the shared definition, got %s", path, node.Raw)
+		}
+	}
+	if strings.Contains(string(output), "$defs") {
+		t.Errorf("Expected request-level $defs not forwarded, got %s", output)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_GeminiNativeSchemaPassesThrough(t *testing.T) {
+	schema := `{"type":"object","properties":{"b":{"type":"string","format":"date-time"},"a":{"type":"integer","minimum":1}},"propertyOrdering":["b","a"],"required":["a"]}`
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": "Hi"}],
+		"tools": [
+			{"name": "native", "input_schema": ` + schema + `},
+			{"name": "flagged", "input_schema": {"type":"object","properties":{"n":{"type":"integer","minimum":1}},"x-gemini-native":true}}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	if got := gjson.GetBytes(output, "request.tools.0.functionDeclarations.0.parametersJsonSchema").Raw; got != schema {
+		t.Errorf("Expected Gemini-native schema left as-is,\ngot  %s\nwant %s", got, schema)
+	}
+	flagged := gjson.GetBytes(output, "request.tools.0.functionDeclarations.1.parametersJsonSchema")
+	if flagged.Get("properties.n.minimum").Int() != 1 {
+		t.Errorf("Expected flagged schema to keep its keywords, got %s", flagged.Raw)
+	}
+	if flagged.Get("x-gemini-native").Exists() {
+		t.Errorf("Expected vendor marker stripped, got %s", flagged.Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithWarnings(t *testing.T) {
+	SetMaxToolDeclarations(1)
+	defer SetMaxToolDeclarations(0)
+
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "Hi"},
+				{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "AAAA"}},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/bmp", "data": "AAAA"}},
+				{"type": "tool_result", "tool_use_id": "toolu_orphan", "content": "x"}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "unsigned", "signature": ""},
+				{"type": "text", "text": "Hello"}
+			]}
+		],
+		"tools": [
+			{"name": "a", "input_schema": {"type": "object"}},
+			{"name": "b", "input_schema": {"type": "object"}}
+		],
+		"safety_settings": [{"category": "HARM_CATEGORY_BOGUS", "threshold": "OFF"}]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if !gjson.ValidBytes(output) {
+		t.Fatalf("Expected valid output, got %s", output)
+	}
+
+	got := make(map[string]int)
+	for _, warning := range warnings {
+		if warning.Message == "" {
+			t.Errorf("Expected a message for warning %s", warning.Code)
+		}
+		got[warning.Code]++
+	}
+	for _, code := range []string{
+		WarningDroppedUnknownBlock,
+		WarningUnsupportedImage,
+		WarningDroppedToolResult,
+		WarningDroppedUnsignedThinking,
+		WarningDroppedTools,
+		WarningInvalidSafetySettings,
+	} {
+		if got[code] != 1 {
+			t.Errorf("Expected exactly one %s warning, got %d (all: %+v)", code, got[code], warnings)
+		}
+	}
+
+	_, warnings = ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", []byte(`{"messages":[{"role":"user","content":"Hi"}]}`), false, RequestOptions{})
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings for a clean request, got %+v", warnings)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithWarnings_ClampedBudget(t *testing.T) {
+	inputJSON := []byte(`{
+		"max_tokens": 4000,
+		"thinking": {"type": "enabled", "budget_tokens": 8000},
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5-thinking", inputJSON, false, RequestOptions{})
+	if len(warnings) != 1 || warnings[0].Code != WarningClampedBudget {
+		t.Fatalf("Expected one clamped_budget warning, got %+v", warnings)
+	}
+	if got := gjson.GetBytes(output, "request.generationConfig.thinkingConfig.thinkingBudget").Int(); got != 3999 {
+		t.Errorf("Expected the budget clamped below max_tokens, got %d", got)
+	}
+
+	inputJSON = []byte(`{
+		"max_tokens": 16000,
+		"thinking": {"type": "enabled", "budget_tokens": 8000},
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`)
+	output, warnings = ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5-thinking", inputJSON, false, RequestOptions{})
+	if len(warnings) != 0 || gjson.GetBytes(output, "request.generationConfig.thinkingConfig.thinkingBudget").Int() != 8000 {
+		t.Errorf("Expected a budget below max_tokens to pass unchanged, got %+v", warnings)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithWarnings_SanitizedToolName(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "user", "content": "List files"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "list files", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "a.go"}]}
+		],
+		"tools": [
+			{"name": "list files", "input_schema": {"type": "object"}},
+			{"name": "read_file", "input_schema": {"type": "object"}}
+		]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if len(warnings) != 1 || warnings[0].Code != WarningSanitizedToolName {
+		t.Fatalf("Expected one sanitized_tool_name warning, got %+v", warnings)
+	}
+	declarations := gjson.GetBytes(output, "request.tools.0.functionDeclarations.#.name").String()
+	if declarations != `["list_files","read_file"]` {
+		t.Errorf("Expected only the invalid name sanitized, got %s", declarations)
+	}
+	if got := gjson.GetBytes(output, "request.contents.1.parts.0.functionCall.name").String(); got != "list_files" {
+		t.Errorf("Expected the replayed call to use the sanitized name, got %q", got)
+	}
+	if got := gjson.GetBytes(output, "request.contents.2.parts.0.functionResponse.name").String(); got != "list_files" {
+		t.Errorf("Expected the function response to use the sanitized name, got %q", got)
+	}
+
+	response := []byte(`{"response":{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"list_files","args":{}}}]},"finishReason":"STOP"}]}}`)
+	claudeResponse := ConvertAntigravityResponseToClaudeNonStream(context.Background(), "claude-sonnet-4-5", inputJSON, output, response, nil)
+	if got := gjson.Get(claudeResponse, "content.0.name").String(); got != "list files" {
+		t.Errorf("Expected the response to restore the declared tool name, got %q in %s", got, claudeResponse)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithWarnings_AnonymousToolResult(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "Hi"},
+			{"type": "tool_result", "content": "orphan"}
+		]}]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if len(warnings) != 1 || warnings[0].Code != WarningDroppedToolResult {
+		t.Fatalf("Expected one dropped_tool_result warning, got %+v", warnings)
+	}
+	if strings.Contains(string(output), "functionResponse") {
+		t.Errorf("Expected the tool_result to be dropped, got %s", output)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MixedStringContentArray(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": ["hello", {"type": "text", "text": "world"}, "   "]}]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if len(warnings) != 0 {
+		t.Errorf("Expected bare strings to be accepted without warnings, got %+v", warnings)
+	}
+	parts := gjson.GetBytes(output, "request.contents.0.parts").Array()
+	if len(parts) != 2 || parts[0].Get("text").String() != "hello" || parts[1].Get("text").String() != "world" {
+		t.Errorf("Expected bare string and text block as two text parts, got %s", gjson.GetBytes(output, "request.contents.0.parts").Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_TextDocument(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": [
+			{"type": "document", "title": "notes.txt", "source": {"type": "text", "media_type": "text/plain", "data": "Line one\nLine two"}},
+			{"type": "text", "text": "Summarize the document"}
+		]}]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if len(warnings) != 0 {
+		t.Errorf("Expected text document to convert without warnings, got %+v", warnings)
+	}
+	parts := gjson.GetBytes(output, "request.contents.0.parts").Array()
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %s", gjson.GetBytes(output, "request.contents.0.parts").Raw)
+	}
+	if parts[0].Get("inlineData").Exists() {
+		t.Error("Expected text document to become a text part, not inlineData")
+	}
+	if got := parts[0].Get("text").String(); got != "notes.txt\n\nLine one\nLine two" {
+		t.Errorf("Unexpected document text %q", got)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithWarnings_ToolCacheControl(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [{"role": "user", "content": "Hi"}],
+		"tools": [
+			{"name": "a", "input_schema": {"type": "object"}},
+			{"name": "b", "input_schema": {"type": "object"}, "cache_control": {"type": "ephemeral"}}
+		]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if len(warnings) != 1 || warnings[0].Code != WarningIgnoredToolCacheControl {
+		t.Fatalf("Expected a single %s warning, got %+v", WarningIgnoredToolCacheControl, warnings)
+	}
+	decls := gjson.GetBytes(output, "request.tools.0.functionDeclarations").Array()
+	if len(decls) != 2 || decls[1].Get("cache_control").Exists() {
+		t.Errorf("Expected both tools forwarded without cache_control, got %s", gjson.GetBytes(output, "request.tools").Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravityWithWarnings_ToolResultCacheControl(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "user", "content": "Read the file"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "read_file", "input": {"path": "a.txt"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "long output", "cache_control": {"type": "ephemeral"}}]}
+		]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+	if len(warnings) != 1 || warnings[0].Code != WarningIgnoredToolResultCacheControl {
+		t.Fatalf("Expected a single %s warning, got %+v", WarningIgnoredToolResultCacheControl, warnings)
+	}
+	response := gjson.GetBytes(output, "request.contents.2.parts.0.functionResponse")
+	if got := response.Get("response.result").String(); got != "long output" {
+		t.Errorf("Expected the annotated tool_result kept, got %s", gjson.GetBytes(output, "request.contents").Raw)
+	}
+	if response.Get("cache_control").Exists() {
+		t.Errorf("Expected cache_control not forwarded, got %s", response.Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ToolResultWithoutContent(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "touch", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1"}]}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	if !gjson.ValidBytes(output) {
+		t.Fatalf("Expected valid JSON, got %s", output)
+	}
+	result := gjson.GetBytes(output, "request.contents.1.parts.0.functionResponse.response.result")
+	if result.Type != gjson.String || result.String() != "" {
+		t.Errorf("Expected empty string result, got %s", result.Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MaxThinkingParts(t *testing.T) {
+	SetMaxThinkingParts(2)
+	defer SetMaxThinkingParts(0)
+
+	const model = "claude-sonnet-4-5-thinking"
+	var messages []string
+	for i := 0; i < 4; i++ {
+		thinkingText := fmt.Sprintf("Thinking about step %d", i)
+		signature := fmt.Sprintf("sig%d_abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz", i)
+		cacheSignature(t, model, thinkingText, signature)
+		messages = append(messages,
+			fmt.Sprintf(`{"role": "user", "content": [{"type": "text", "text": "Step %d"}]}`, i),
+			fmt.Sprintf(`{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": %q, "signature": %q},
+				{"type": "tool_use", "id": "toolu_%d", "name": "run", "input": {"step": %d}}
+			]}`, thinkingText, signature, i, i),
+			fmt.Sprintf(`{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_%d", "content": "ok"}]}`, i),
+		)
+	}
+	inputJSON := []byte(`{
+		"model": "` + model + `",
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"messages": [` + strings.Join(messages, ",") + `],
+		"tools": [{"name": "run", "input_schema": {"type": "object"}}]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity(model, inputJSON, false)
+
+	var thoughts []string
+	calls := 0
+	for _, content := range gjson.GetBytes(output, "request.contents").Array() {
+		for _, part := range content.Get("parts").Array() {
+			if part.Get("thought").Bool() {
+				thoughts = append(thoughts, part.Get("text").String())
+			}
+			if part.Get("functionCall").Exists() {
+				calls++
+				if part.Get("thoughtSignature").String() == "" {
+					t.Errorf("Expected every function call to keep its signature, got %s", part.Raw)
+				}
+			}
+		}
+	}
+	if len(thoughts) != 2 || thoughts[0] != "Thinking about step 2" || thoughts[1] != "Thinking about step 3" {
+		t.Errorf("Expected only the two most recent thought parts, got %v", thoughts)
+	}
+	if calls != 4 {
+		t.Errorf("Expected all 4 function calls kept, got %d", calls)
+	}
+}
+
+func TestLimitThinkingParts_KeepsLastModelTurnAndMergesUsers(t *testing.T) {
+	contents := []string{
+		`{"role":"user","parts":[{"text":"Q1"}]}`,
+		`{"role":"model","parts":[{"thought":true,"text":"old","thoughtSignature":"sig-old"},{"text":""}]}`,
+		`{"role":"user","parts":[{"text":"Q2"}]}`,
+		`{"role":"model","parts":[{"thought":true,"text":"a","thoughtSignature":"sig-a"},{"thought":true,"text":"b","thoughtSignature":"sig-b"},{"functionCall":{"name":"Bash","args":{}},"thoughtSignature":"sig-call"}]}`,
+		`{"role":"user","parts":[{"functionResponse":{"name":"Bash","response":{"result":"ok"}}}]}`,
+	}
+
+	out, dropped := limitThinkingParts(contents, 1)
+	if dropped != 1 {
+		t.Fatalf("Expected only the older turn's thought dropped, got %d", dropped)
+	}
+	if len(out) != 3 {
+		t.Fatalf("Expected the thought-only turn removed and the users merged, got %v", out)
+	}
+	if got := gjson.Get(out[0], "parts.#.text").Raw; got != `["Q1","Q2"]` {
+		t.Errorf("Expected adjacent user turns merged, got %s", out[0])
+	}
+	if got := gjson.Get(out[1], "parts.#(thought==true)#").Array(); len(got) != 2 {
+		t.Errorf("Expected the last model turn's thinking kept, got %s", out[1])
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_EnforceTurnAlternation(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "assistant", "content": "Hello, how can I help?"},
+			{"role": "user", "content": "First question"},
+			{"role": "user", "content": "Second question"}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	if got := gjson.GetBytes(output, "request.contents.#").Int(); got != 3 {
+		t.Fatalf("Expected contents unchanged by default, got %s", gjson.GetBytes(output, "request.contents").Raw)
+	}
+
+	SetEnforceTurnAlternation(true)
+	defer SetEnforceTurnAlternation(false)
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	contents := gjson.GetBytes(output, "request.contents").Array()
+	wantRoles := []string{"user", "model", "user", "model", "user"}
+	if len(contents) != len(wantRoles) {
+		t.Fatalf("Expected %d turns, got %s", len(wantRoles), gjson.GetBytes(output, "request.contents").Raw)
+	}
+	for i, content := range contents {
+		if got := content.Get("role").String(); got != wantRoles[i] {
+			t.Errorf("turn %d: expected role %s, got %s", i, wantRoles[i], got)
+		}
+	}
+	if got := contents[1].Get("parts.0.text").String(); got != "Hello, how can I help?" {
+		t.Errorf("Expected original assistant turn after the filler, got %q", got)
+	}
+	if got := contents[4].Get("parts.0.text").String(); got != "Second question" {
+		t.Errorf("Expected last user turn preserved, got %q", got)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_UnsignedThinkingModes(t *testing.T) {
+	defer SetUnsignedThinkingMode("")
+
+	inputJSON := []byte(`{
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"messages": [
+			{"role": "user", "content": "Hi"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "Unsigned reasoning", "signature": ""},
+				{"type": "text", "text": "Hello"}
+			]},
+			{"role": "user", "content": "Again"}
+		]
+	}`)
+
+	tests := []struct {
+		mode         string
+		wantParts    int
+		wantWarning  bool
+		wantThinking bool
+	}{
+		{mode: UnsignedThinkingDrop, wantParts: 1, wantWarning: true},
+		{mode: UnsignedThinkingStripToText, wantParts: 2, wantThinking: true},
+		// The former keep-unsigned mode is unknown now and falls back to drop.
+		{mode: "keep-unsigned", wantParts: 1, wantWarning: true},
+	}
+	for _, tt := range tests {
+		SetUnsignedThinkingMode(tt.mode)
+		output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5-thinking", inputJSON, false, RequestOptions{})
+
+		parts := gjson.GetBytes(output, "request.contents.1.parts").Array()
+		if len(parts) != tt.wantParts {
+			t.Errorf("mode %s: expected %d parts, got %s", tt.mode, tt.wantParts, gjson.GetBytes(output, "request.contents.1.parts").Raw)
+			continue
+		}
+		if tt.wantParts == 2 {
+			if got := parts[0].Get("text").String(); got != "Unsigned reasoning" {
+				t.Errorf("mode %s: expected thinking text kept, got %q", tt.mode, got)
+			}
+			if parts[0].Get("thought").Exists() || parts[0].Get("thoughtSignature").Exists() {
+				t.Errorf("mode %s: expected a plain text part, got %s", tt.mode, parts[0].Raw)
+			}
+		}
+		hasWarning := len(warnings) == 1 && warnings[0].Code == WarningDroppedUnsignedThinking
+		if hasWarning != tt.wantWarning {
+			t.Errorf("mode %s: expected drop warning=%v, got %+v", tt.mode, tt.wantWarning, warnings)
+		}
+		if got := gjson.GetBytes(output, "request.generationConfig.thinkingConfig").Exists(); got != tt.wantThinking {
+			t.Errorf("mode %s: expected thinkingConfig=%v, got %s", tt.mode, tt.wantThinking, output)
+		}
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_DuplicateThinkingAcrossTurns(t *testing.T) {
+	cache.ClearSignatureCache("")
+
+	validSignature := "abc123validSignature1234567890123456789012345678901234567890"
+	thinkingText := "I should look this up"
+	cacheSignature(t, "claude-sonnet-4-5-thinking", thinkingText, validSignature)
+
+	thinkingThenTool := func(id string) string {
+		return `{"role": "assistant", "content": [
+			{"type": "thinking", "thinking": "` + thinkingText + `", "signature": "` + validSignature + `"},
+			{"type": "tool_use", "id": "` + id + `", "name": "search", "input": {"q": "x"}}
+		]}`
+	}
+	toolResult := func(id string) string {
+		return `{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "` + id + `", "content": "ok"}]}`
+	}
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5-thinking",
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Find it"}]},
+			` + thinkingThenTool("toolu_1") + `,
+			` + toolResult("toolu_1") + `,
+			` + thinkingThenTool("toolu_2") + `,
+			` + toolResult("toolu_2") + `,
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_3", "name": "search", "input": {"q": "y"}}]},
+			` + toolResult("toolu_3") + `
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", inputJSON, false)
+
+	for _, idx := range []int{1, 3} {
+		parts := gjson.GetBytes(output, fmt.Sprintf("request.contents.%d.parts", idx)).Array()
+		if len(parts) != 2 || !parts[0].Get("thought").Bool() {
+			t.Fatalf("Expected thought and functionCall in turn %d, got %s", idx, gjson.GetBytes(output, "request.contents").Raw)
+		}
+		if got := parts[1].Get("thoughtSignature").String(); got != validSignature {
+			t.Errorf("Expected tool call in turn %d to carry its own message's signature, got %q", idx, got)
+		}
+	}
+
+	// A tool call in a message without thinking must not inherit an earlier turn's signature.
+	lastCall := gjson.GetBytes(output, "request.contents.5.parts.0")
+	if !lastCall.Get("functionCall").Exists() {
+		t.Fatalf("Expected functionCall in turn 5, got %s", lastCall.Raw)
+	}
+	if got := lastCall.Get("thoughtSignature").String(); got != "skip_thought_signature_validator" {
+		t.Errorf("Expected skip sentinel for tool call without thinking, got %q", got)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MetadataLabels(t *testing.T) {
+	inputJSON := []byte(`{
+		"metadata": {"user_id": "User_ABC.123_account_x_session_5F3A-99"},
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`)
+
+	if output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false); gjson.GetBytes(output, "request.labels").Exists() {
+		t.Fatalf("Expected no labels unless enabled, got %s", gjson.GetBytes(output, "request.labels").Raw)
+	}
+
+	SetMetadataLabels(true)
+	defer SetMetadataLabels(false)
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	labels := gjson.GetBytes(output, "request.labels")
+	if got := labels.Get("user_id").String(); got != "user_abc_123_account_x_session_5f3a-99" {
+		t.Errorf("Expected sanitized user_id label, got %q", got)
+	}
+	if got := labels.Get("session_id").String(); got != "5f3a-99" {
+		t.Errorf("Expected session_id label, got %q", got)
+	}
+
+	long := []byte(`{"metadata":{"user_id":"` + strings.Repeat("a", 100) + `"},"messages":[{"role":"user","content":"Hi"}]}`)
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", long, false)
+	if got := gjson.GetBytes(output, "request.labels.user_id").String(); len(got) != 63 {
+		t.Errorf("Expected label value truncated to 63 characters, got %d", len(got))
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_OutputIsByteStable(t *testing.T) {
+	inputJSON := []byte(`{
+		"model": "claude-sonnet-4-5",
+		"system": [{"type": "text", "text": "Be brief."}],
+		"metadata": {"user_id": "user_1_session_abc"},
+		"$defs": {"B": {"type": "string"}, "A": {"type": "integer"}},
+		"messages": [
+			{"role": "user", "content": "Look up"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {"z": 1, "a": {"y": 2, "b": 3}}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": {"zeta": 1, "alpha": [3, 2, 1], "mid": {"k2": "v", "k1": "v"}}}]}
+		],
+		"tools": [{"name": "lookup", "input_schema": {"type": "object", "properties": {"b": {"$ref": "#/$defs/B"}, "a": {"$ref": "#/$defs/A"}}}}],
+		"safety_settings": [{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_NONE"}, {"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}],
+		"temperature": 0.5,
+		"max_tokens": 1024
+	}`)
+
+	SetMetadataLabels(true)
+	defer SetMetadataLabels(false)
+
+	golden := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	for i := 0; i < 50; i++ {
+		if output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false); string(output) != string(golden) {
+			t.Fatalf("Expected byte-identical output on run %d,\ngot  %s\nwant %s", i, output, golden)
+		}
+	}
+	if got := gjson.GetBytes(golden, "request.contents.2.parts.0.functionResponse.response.result").Raw; !strings.HasPrefix(got, `{"zeta": 1, "alpha"`) {
+		t.Errorf("Expected functionResponse payload to keep the client's key order, got %s", got)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ToolResultMatchedByName(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "user", "content": "Check both"},
+			{"role": "assistant", "content": [
+				{"type": "tool_use", "id": "toolu_a", "name": "lookup", "input": {"q": "a"}},
+				{"type": "tool_use", "id": "toolu_b", "name": "lookup", "input": {"q": "b"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "name": "lookup", "content": "first"},
+				{"type": "tool_result", "name": "lookup", "content": "second"},
+				{"type": "tool_result", "name": "unknown_tool", "content": "orphan"}
+			]}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	parts := gjson.GetBytes(output, "request.contents.2.parts").Array()
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 functionResponse parts, got %d: %s", len(parts), gjson.GetBytes(output, "request.contents").Raw)
+	}
+	for i, want := range []struct{ id, result string }{{"toolu_a", "first"}, {"toolu_b", "second"}} {
+		response := parts[i].Get("functionResponse")
+		if response.Get("id").String() != want.id || response.Get("name").String() != "lookup" || response.Get("response.result").String() != want.result {
+			t.Errorf("Expected name-only tool_result %d to answer %s, got %s", i, want.id, response.Raw)
+		}
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_UserTurnFunctionResponsesFirst(t *testing.T) {
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "user", "content": "Run it"},
+			{"role": "assistant", "content": [
+				{"type": "tool_use", "id": "toolu_1", "name": "run", "input": {}},
+				{"type": "tool_use", "id": "toolu_2", "name": "shot", "input": {}}
+			]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "Here you go"},
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "done"},
+				{"type": "text", "text": "And a screenshot"},
+				{"type": "tool_result", "tool_use_id": "toolu_2", "content": [
+					{"type": "text", "text": "captured"},
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+				]}
+			]}
+		]
+	}`)
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+
+	parts := gjson.GetBytes(output, "request.contents.2.parts").Array()
+	if len(parts) != 5 {
+		t.Fatalf("Expected 5 parts, got %d: %s", len(parts), gjson.GetBytes(output, "request.contents.2").Raw)
+	}
+	if parts[0].Get("functionResponse.id").String() != "toolu_1" || parts[1].Get("functionResponse.id").String() != "toolu_2" {
+		t.Errorf("Expected function responses first in block order, got %s", gjson.GetBytes(output, "request.contents.2.parts").Raw)
+	}
+	if !parts[2].Get("inlineData").Exists() {
+		t.Errorf("Expected tool_result image to follow its function responses, got %s", parts[2].Raw)
+	}
+	if parts[3].Get("text").String() != "Here you go" || parts[4].Get("text").String() != "And a screenshot" {
+		t.Errorf("Expected text parts after function responses in block order, got %s", gjson.GetBytes(output, "request.contents.2.parts").Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MaxRequestParts(t *testing.T) {
+	SetMaxRequestParts(5)
+	defer SetMaxRequestParts(0)
+
+	var blocks []string
+	for i := 0; i < 4; i++ {
+		blocks = append(blocks, fmt.Sprintf(`{"type":"text","text":"old %d"}`, i))
+	}
+	inputJSON := []byte(`{
+		"messages": [
+			{"role": "user", "content": [` + strings.Join(blocks, ",") + `]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "run", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"}]},
+			{"role": "assistant", "content": "Done"},
+			{"role": "user", "content": [{"type": "text", "text": "next"}, {"type": "text", "text": "really"}]}
+		]
+	}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+
+	contents := gjson.GetBytes(output, "request.contents").Array()
+	if len(contents) != 1 || contents[0].Get("parts.0.text").String() != "next" {
+		t.Fatalf("Expected only the last turn to survive the cap, got %s", gjson.GetBytes(output, "request.contents").Raw)
+	}
+	if len(warnings) != 1 || warnings[0].Code != WarningDroppedTurns {
+		t.Errorf("Expected a dropped_turns warning, got %+v", warnings)
+	}
+
+	SetMaxRequestParts(100)
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", inputJSON, false)
+	if n := len(gjson.GetBytes(output, "request.contents").Array()); n != 5 {
+		t.Errorf("Expected all turns under the cap, got %d", n)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MaxRequestPartsSingleTurn(t *testing.T) {
+	SetMaxRequestParts(3)
+	defer SetMaxRequestParts(0)
+
+	var blocks []string
+	for i := 0; i < 10; i++ {
+		blocks = append(blocks, fmt.Sprintf(`{"type":"text","text":"block %d"}`, i))
+	}
+	inputJSON := []byte(`{"messages": [{"role": "user", "content": [` + strings.Join(blocks, ",") + `]}]}`)
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", inputJSON, false, RequestOptions{})
+
+	parts := gjson.GetBytes(output, "request.contents.0.parts").Array()
+	if len(parts) != 3 || parts[0].Get("text").String() != "block 7" || parts[2].Get("text").String() != "block 9" {
+		t.Fatalf("Expected the newest 3 parts of the only turn, got %s", gjson.GetBytes(output, "request.contents").Raw)
+	}
+	if len(warnings) != 1 || warnings[0].Code != WarningTruncatedTurn {
+		t.Errorf("Expected a truncated_turn warning, got %+v", warnings)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_MaxRequestPartsToolLoop(t *testing.T) {
+	SetMaxRequestParts(2)
+	defer SetMaxRequestParts(0)
+
+	toolLoop := func(trailing string) []byte {
+		return []byte(`{
+			"messages": [
+				{"role": "user", "content": "Run the checks"},
+				{"role": "assistant", "content": [
+					{"type": "tool_use", "id": "toolu_1", "name": "lint", "input": {}},
+					{"type": "tool_use", "id": "toolu_2", "name": "test", "input": {}},
+					{"type": "tool_use", "id": "toolu_3", "name": "vet", "input": {}}
+				]},
+				{"role": "user", "content": [
+					{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"},
+					{"type": "tool_result", "tool_use_id": "toolu_2", "content": "ok"},
+					{"type": "tool_result", "tool_use_id": "toolu_3", "content": "ok"}` + trailing + `
+				]}
+			]
+		}`)
+	}
+
+	output, warnings := ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", toolLoop(`, {"type": "text", "text": "Summarize"}`), false, RequestOptions{})
+	contents := gjson.GetBytes(output, "request.contents").Array()
+	if len(contents) != 1 {
+		t.Fatalf("Expected only the last turn to survive the cap, got %s", gjson.GetBytes(output, "request.contents").Raw)
+	}
+	parts := contents[0].Get("parts").Array()
+	if len(parts) != 1 || parts[0].Get("text").String() != "Summarize" {
+		t.Errorf("Expected the orphaned function responses to be dropped with their calls, got %s", contents[0].Raw)
+	}
+	codes := make([]string, 0, len(warnings))
+	for _, warning := range warnings {
+		codes = append(codes, warning.Code)
+	}
+	if strings.Join(codes, ",") != WarningDroppedTurns+","+WarningDroppedToolResult {
+		t.Errorf("Expected dropped_turns and dropped_tool_result warnings, got %+v", warnings)
+	}
+
+	// A last turn of nothing but responses cannot be capped without its calls.
+	output, warnings = ConvertClaudeRequestToAntigravityWithWarnings("claude-sonnet-4-5", toolLoop(""), false, RequestOptions{})
+	if n := len(gjson.GetBytes(output, "request.contents").Array()); n != 3 {
+		t.Errorf("Expected the tool loop to be forwarded uncapped, got %d turns", n)
+	}
+	if len(warnings) != 1 || warnings[0].Code != WarningToolLoopOverLimit {
+		t.Errorf("Expected a tool_loop_over_limit warning, got %+v", warnings)
+	}
+	if _, _, err := ConvertClaudeRequestToAntigravityE("claude-sonnet-4-5", toolLoop(""), false, RequestOptions{}); err == nil {
+		t.Error("Expected the E variant to reject a tool loop over max-request-parts")
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_TruncatedAssistantTurnKeepsOrder(t *testing.T) {
+	cache.ClearSignatureCache("")
+
+	validSignature := "abc123validSignature1234567890123456789012345678901234567890"
+	thinkingText := "Planning the rest"
+	cacheSignature(t, "claude-sonnet-4-5-thinking", thinkingText, validSignature)
+
+	assistant := func(stopReason string) string {
+		return `{"role": "assistant", "stop_reason": "` + stopReason + `", "content": [
+			{"type": "text", "text": "Part one of the essay"},
+			{"type": "thinking", "thinking": "` + thinkingText + `", "signature": "` + validSignature + `"}
+		]}`
+	}
+	build := func(stopReason string) []byte {
+		return []byte(`{
+			"model": "claude-sonnet-4-5-thinking",
+			"messages": [
+				{"role": "user", "content": "Write an essay"},
+				` + assistant(stopReason) + `,
+				{"role": "user", "content": "Continue"}
+			]
+		}`)
+	}
+
+	output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", build("max_tokens"), false)
+	parts := gjson.GetBytes(output, "request.contents.1.parts").Array()
+	if len(parts) != 2 || parts[0].Get("text").String() != "Part one of the essay" || !parts[1].Get("thought").Bool() {
+		t.Errorf("Expected truncated turn replayed in generated order, got %s", gjson.GetBytes(output, "request.contents.1").Raw)
+	}
+
+	output = ConvertClaudeRequestToAntigravity("claude-sonnet-4-5-thinking", build("end_turn"), false)
+	if !gjson.GetBytes(output, "request.contents.1.parts.0.thought").Bool() {
+		t.Errorf("Expected complete turn to keep thinking first, got %s", gjson.GetBytes(output, "request.contents.1").Raw)
+	}
+}
+
+func TestConvertClaudeRequestToAntigravity_ParallelToolCallsDisabled(t *testing.T) {
+	base := `"messages": [{"role": "user", "content": "Hi"}], "tools": [{"name": "run", "input_schema": {"type": "object"}}]`
+	hasHint := func(output []byte) bool {
+		for _, part := range gjson.GetBytes(output, "request.systemInstruction.parts").Array() {
+			if strings.Contains(part.Get("text").String(), "at most one tool") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for name, extra := range map[string]string{
+		"parallel_tool_calls":       `"parallel_tool_calls": false`,
+		"disable_parallel_tool_use": `"tool_choice": {"type": "auto", "disable_parallel_tool_use": true}`,
+	} {
+		output := ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", []byte(`{`+base+`, `+extra+`}`), false)
+		if !hasHint(output) {
+			t.Errorf("%s: expected single-tool-call instruction, got %s", name, gjson.GetBytes(output, "request.systemInstruction").Raw)
+		}
+	}
+
+	withSystem := []byte(`{"system": "Be brief.", ` + base + `, "parallel_tool_calls": false}`)
+	parts := gjson.GetBytes(ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", withSystem, false), "request.systemInstruction.parts").Array()
+	if len(parts) != 2 || parts[0].Get("text").String() != "Be brief." {
+		t.Errorf("Expected instruction appended after the client system prompt, got %d parts", len(parts))
+	}
+
+	if hasHint(ConvertClaudeRequestToAntigravity("claude-sonnet-4-5", []byte(`{`+base+`, "parallel_tool_calls": true}`), false)) {
+		t.Error("Expected no instruction when parallel tool calls are allowed")
+	}
+}
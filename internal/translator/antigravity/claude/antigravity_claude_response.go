@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/tidwall/gjson"
@@ -40,7 +41,13 @@ type Params struct {
 	HasToolUse           bool   // Indicates if tool use was observed in the stream
 	HasContent           bool   // Tracks whether any content (text, thinking, or tool use) has been output
 
+	// Streamed function-call arguments (functionCall.partialArgs with willContinue)
+	ToolArgsStreaming    bool   // A tool_use block is open and still receiving partialArgs
+	ToolArgsBuffer       string // Arguments accumulated so far, flushed as one input_json_delta
+	ToolArgsContinuePath string // Path of a string argument whose value continues in the next chunk
+
 	// Signature caching support
+	SessionID           string          // Session ID derived from the originating request, for log correlation
 	CurrentThinkingText strings.Builder // Accumulates thinking text for signature caching
 }
 
@@ -69,6 +76,7 @@ func ConvertAntigravityResponseToClaude(_ context.Context, _ string, originalReq
 			HasFirstResponse: false,
 			ResponseType:     0,
 			ResponseIndex:    0,
+			SessionID:        DeriveSessionID(originalRequestRawJSON),
 		}
 	}
 	modelName := gjson.GetBytes(requestRawJSON, "model").String()
@@ -130,6 +138,19 @@ func ConvertAntigravityResponseToClaude(_ context.Context, _ string, originalReq
 			partTextResult := partResult.Get("text")
 			functionCallResult := partResult.Get("functionCall")
 
+			// Continuation chunks of a streamed function call carry no name; fold their
+			// partialArgs into the open tool_use block.
+			if params.ToolArgsStreaming {
+				if functionCallResult.Exists() && functionCallResult.Get("name").String() == "" {
+					appendPartialToolArgs(params, functionCallResult)
+					if !functionCallResult.Get("willContinue").Bool() {
+						flushToolArgs(params, &output)
+					}
+					continue
+				}
+				flushToolArgs(params, &output)
+			}
+
 			// Handle text content (both regular content and thinking)
 			if partTextResult.Exists() {
 				// Process thinking content (internal reasoning)
@@ -139,7 +160,7 @@ func ConvertAntigravityResponseToClaude(_ context.Context, _ string, originalReq
 
 						if params.CurrentThinkingText.Len() > 0 {
 							cache.CacheSignature(modelName, params.CurrentThinkingText.String(), thoughtSignature.String())
-							// log.Debugf("Cached signature for thinking block (sessionID=%s, textLen=%d)", params.SessionID, params.CurrentThinkingText.Len())
+							log.Debugf("antigravity claude response: cached thinking signature (session=%s, text_len=%d)", params.SessionID, params.CurrentThinkingText.Len())
 							params.CurrentThinkingText.Reset()
 						}
 
@@ -223,7 +244,7 @@ func ConvertAntigravityResponseToClaude(_ context.Context, _ string, originalReq
 				// Handle function/tool calls from the AI model
 				// This processes tool usage requests and formats them for Claude Code API compatibility
 				params.HasToolUse = true
-				fcName := functionCallResult.Get("name").String()
+				fcName := clientToolName(originalRequestRawJSON, functionCallResult.Get("name").String())
 
 				// Handle state transitions when switching to function calls
 				// Close any existing function call block first
@@ -260,13 +281,40 @@ func ConvertAntigravityResponseToClaude(_ context.Context, _ string, originalReq
 				data, _ = sjson.Set(data, "content_block.name", fcName)
 				output = output + fmt.Sprintf("data: %s\n\n\n", data)
 
-				if fcArgsResult := functionCallResult.Get("args"); fcArgsResult.Exists() {
+				if functionCallResult.Get("willContinue").Bool() {
+					// Arguments follow in partialArgs chunks; buffer them so the client receives
+					// one complete JSON object when the call finishes.
+					params.ToolArgsStreaming = true
+					params.ToolArgsBuffer = "{}"
+					params.ToolArgsContinuePath = ""
+					if fcArgsResult := functionCallResult.Get("args"); fcArgsResult.IsObject() {
+						params.ToolArgsBuffer = fcArgsResult.Raw
+					}
+					appendPartialToolArgs(params, functionCallResult)
+				} else if fcArgsResult := functionCallResult.Get("args"); fcArgsResult.Exists() {
 					output = output + "event: content_block_delta\n"
 					data, _ = sjson.Set(fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"input_json_delta","partial_json":""}}`, params.ResponseIndex), "delta.partial_json", fcArgsResult.Raw)
 					output = output + fmt.Sprintf("data: %s\n\n\n", data)
 				}
 				params.ResponseType = 3
 				params.HasContent = true
+			} else if inlineDataResult := partResult.Get("inlineData"); inlineDataResult.Exists() {
+				// Images generated by the model arrive whole, so each becomes a complete image block
+				if params.ResponseType != 0 {
+					output = output + "event: content_block_stop\n"
+					output = output + fmt.Sprintf(`data: {"type":"content_block_stop","index":%d}`, params.ResponseIndex)
+					output = output + "\n\n\n"
+					params.ResponseIndex++
+					params.ResponseType = 0
+				}
+				output = output + "event: content_block_start\n"
+				data, _ := sjson.SetRaw(fmt.Sprintf(`{"type":"content_block_start","index":%d,"content_block":{}}`, params.ResponseIndex), "content_block", claudeImageBlock(inlineDataResult))
+				output = output + fmt.Sprintf("data: %s\n\n\n", data)
+				output = output + "event: content_block_stop\n"
+				output = output + fmt.Sprintf(`data: {"type":"content_block_stop","index":%d}`, params.ResponseIndex)
+				output = output + "\n\n\n"
+				params.ResponseIndex++
+				params.HasContent = true
 			}
 		}
 	}
@@ -312,6 +360,8 @@ func appendFinalEvents(params *Params, output *string, force bool) {
 		return
 	}
 
+	flushToolArgs(params, output)
+
 	if params.ResponseType != 0 {
 		*output = *output + "event: content_block_stop\n"
 		*output = *output + fmt.Sprintf(`data: {"type":"content_block_stop","index":%d}`, params.ResponseIndex)
@@ -344,6 +394,123 @@ func appendFinalEvents(params *Params, output *string, force bool) {
 	params.HasSentFinalEvents = true
 }
 
+// appendPartialToolArgs merges the partialArgs of a streamed functionCall chunk into the buffer.
+// A string value whose previous fragment had willContinue set is appended to rather than replaced.
+func appendPartialToolArgs(params *Params, functionCallResult gjson.Result) {
+	for _, arg := range functionCallResult.Get("partialArgs").Array() {
+		path, ok := partialArgPath(arg.Get("jsonPath").String())
+		if !ok {
+			log.Warnf("antigravity claude response: ignoring partial argument with unsupported path %q", arg.Get("jsonPath").String())
+			continue
+		}
+		switch {
+		case arg.Get("stringValue").Exists():
+			value := arg.Get("stringValue").String()
+			if params.ToolArgsContinuePath == path {
+				value = gjson.Get(params.ToolArgsBuffer, path).String() + value
+			}
+			params.ToolArgsBuffer, _ = sjson.Set(params.ToolArgsBuffer, path, value)
+		case arg.Get("numberValue").Exists():
+			params.ToolArgsBuffer, _ = sjson.SetRaw(params.ToolArgsBuffer, path, arg.Get("numberValue").Raw)
+		case arg.Get("boolValue").Exists():
+			params.ToolArgsBuffer, _ = sjson.Set(params.ToolArgsBuffer, path, arg.Get("boolValue").Bool())
+		case arg.Get("nullValue").Exists():
+			params.ToolArgsBuffer, _ = sjson.SetRaw(params.ToolArgsBuffer, path, "null")
+		}
+		params.ToolArgsContinuePath = ""
+		if arg.Get("willContinue").Bool() {
+			params.ToolArgsContinuePath = path
+		}
+	}
+}
+
+// flushToolArgs emits the buffered arguments of a streamed function call as a single
+// input_json_delta. The tool_use block itself stays open and is closed by the caller.
+func flushToolArgs(params *Params, output *string) {
+	if !params.ToolArgsStreaming {
+		return
+	}
+	*output = *output + "event: content_block_delta\n"
+	data, _ := sjson.Set(fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"input_json_delta","partial_json":""}}`, params.ResponseIndex), "delta.partial_json", params.ToolArgsBuffer)
+	*output = *output + fmt.Sprintf("data: %s\n\n\n", data)
+	params.ToolArgsStreaming = false
+	params.ToolArgsBuffer = ""
+	params.ToolArgsContinuePath = ""
+}
+
+// partialArgPath converts a partialArgs JSONPath such as "$.items[0].name" into an sjson path.
+func partialArgPath(jsonPath string) (string, bool) {
+	rest, ok := strings.CutPrefix(jsonPath, "$")
+	if !ok || rest == "" {
+		return "", false
+	}
+	var segments []string
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return "", false
+			}
+			segments = append(segments, escapeArgPathKey(rest[:end]))
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return "", false
+			}
+			inner := rest[1:end]
+			if quoted := strings.Trim(inner, `'"`); len(quoted) == len(inner)-2 && len(inner) >= 2 {
+				segments = append(segments, escapeArgPathKey(quoted))
+			} else if inner != "" && strings.Trim(inner, "0123456789") == "" {
+				segments = append(segments, inner)
+			} else {
+				return "", false
+			}
+			rest = rest[end+1:]
+		default:
+			return "", false
+		}
+	}
+	return strings.Join(segments, "."), true
+}
+
+var argPathKeyReplacer = strings.NewReplacer(".", "\\.", "*", "\\*", "?", "\\?")
+
+func escapeArgPathKey(key string) string {
+	return argPathKeyReplacer.Replace(key)
+}
+
+// clientToolName maps a function name the request translator sanitized back to the name of the
+// tool the client declared, so tool_use blocks always reference a known tool.
+func clientToolName(originalRequestRawJSON []byte, name string) string {
+	for _, tool := range gjson.GetBytes(originalRequestRawJSON, "tools").Array() {
+		if declared := tool.Get("name").String(); declared != name && util.SanitizeFunctionName(declared) == name {
+			return declared
+		}
+	}
+	return name
+}
+
+// claudeImageBlock converts a Gemini inlineData part into a Claude base64 image content block.
+func claudeImageBlock(inlineData gjson.Result) string {
+	mimeType := inlineData.Get("mimeType").String()
+	if mimeType == "" {
+		mimeType = inlineData.Get("mime_type").String()
+	}
+	block := `{"type":"image","source":{"type":"base64","media_type":"","data":""}}`
+	block, _ = sjson.Set(block, "source.media_type", mimeType)
+	if data := inlineData.Get("data"); data.Type == gjson.String {
+		// Splice the raw string to avoid copying large image data through unescaping
+		block, _ = sjson.SetRaw(block, "source.data", data.Raw)
+	}
+	return block
+}
+
 func resolveStopReason(params *Params) string {
 	if params.HasToolUse {
 		return "tool_use"
@@ -370,7 +537,6 @@ func resolveStopReason(params *Params) string {
 // Returns:
 //   - string: A Claude-compatible JSON response.
 func ConvertAntigravityResponseToClaudeNonStream(_ context.Context, _ string, originalRequestRawJSON, requestRawJSON, rawJSON []byte, _ *any) string {
-	_ = originalRequestRawJSON
 	modelName := gjson.GetBytes(requestRawJSON, "model").String()
 
 	root := gjson.ParseBytes(rawJSON)
@@ -472,7 +638,7 @@ func ConvertAntigravityResponseToClaudeNonStream(_ context.Context, _ string, or
 				flushText()
 				hasToolCall = true
 
-				name := functionCall.Get("name").String()
+				name := clientToolName(originalRequestRawJSON, functionCall.Get("name").String())
 				toolIDCounter++
 				toolBlock := `{"type":"tool_use","id":"","name":"","input":{}}`
 				toolBlock, _ = sjson.Set(toolBlock, "id", fmt.Sprintf("tool_%d", toolIDCounter))
@@ -486,6 +652,14 @@ func ConvertAntigravityResponseToClaudeNonStream(_ context.Context, _ string, or
 				responseJSON, _ = sjson.SetRaw(responseJSON, "content.-1", toolBlock)
 				continue
 			}
+
+			if inlineData := part.Get("inlineData"); inlineData.Exists() {
+				flushThinking()
+				flushText()
+				ensureContentArray()
+				responseJSON, _ = sjson.SetRaw(responseJSON, "content.-1", claudeImageBlock(inlineData))
+				continue
+			}
 		}
 	}
 
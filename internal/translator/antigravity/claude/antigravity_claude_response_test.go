@@ -6,6 +6,9 @@ import (
 	"testing"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/tidwall/gjson"
 )
 
 // ============================================================================
@@ -95,6 +98,11 @@ func TestConvertAntigravityResponseToClaude_ThinkingTextAccumulated(t *testing.T
 
 func TestConvertAntigravityResponseToClaude_SignatureCached(t *testing.T) {
 	cache.ClearSignatureCache("")
+	hook := test.NewLocal(log.StandardLogger())
+	defer hook.Reset()
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(level)
 
 	requestJSON := []byte(`{
 		"model": "claude-sonnet-4-5-thinking",
@@ -153,6 +161,17 @@ func TestConvertAntigravityResponseToClaude_SignatureCached(t *testing.T) {
 	if params.CurrentThinkingText.Len() != 0 {
 		t.Error("Thinking text should be reset after signature is cached")
 	}
+
+	// The cache log carries the session ID so it can be correlated with the request
+	logged := false
+	for _, entry := range hook.AllEntries() {
+		if strings.Contains(entry.Message, "cached thinking signature") && strings.Contains(entry.Message, "session="+sessionID) {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Errorf("Expected the cached signature to be logged with session %q", sessionID)
+	}
 }
 
 func TestConvertAntigravityResponseToClaude_MultipleThinkingBlocks(t *testing.T) {
@@ -246,3 +265,95 @@ func TestConvertAntigravityResponseToClaude_MultipleThinkingBlocks(t *testing.T)
 		t.Error("Second thinking block signature should be cached")
 	}
 }
+
+func TestConvertAntigravityResponseToClaude_StreamedFunctionCallArgs(t *testing.T) {
+	requestJSON := []byte(`{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"Weather?"}]}`)
+	chunks := []string{
+		`{"response":{"candidates":[{"content":{"parts":[{"functionCall":{"name":"get_weather","willContinue":true}}]}}]}}`,
+		`{"response":{"candidates":[{"content":{"parts":[{"functionCall":{"partialArgs":[{"jsonPath":"$.location","stringValue":"San Fr","willContinue":true}],"willContinue":true}}]}}]}}`,
+		`{"response":{"candidates":[{"content":{"parts":[{"functionCall":{"partialArgs":[{"jsonPath":"$.location","stringValue":"ancisco"},{"jsonPath":"$.days","numberValue":3}],"willContinue":true}}]}}]}}`,
+		`{"response":{"candidates":[{"content":{"parts":[{"functionCall":{"partialArgs":[{"jsonPath":"$.units[0]","stringValue":"celsius"},{"jsonPath":"$.alerts","boolValue":true}]}}]}}]}}`,
+		`{"response":{"candidates":[{"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":7,"totalTokenCount":12}}}`,
+	}
+
+	var param any
+	var output strings.Builder
+	for _, chunk := range chunks {
+		for _, out := range ConvertAntigravityResponseToClaude(context.Background(), "", requestJSON, requestJSON, []byte(chunk), &param) {
+			output.WriteString(out)
+		}
+	}
+
+	var partialJSON strings.Builder
+	starts, stops := 0, 0
+	for _, line := range strings.Split(output.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch gjson.Get(data, "type").String() {
+		case "content_block_start":
+			starts++
+		case "content_block_stop":
+			stops++
+		case "content_block_delta":
+			if gjson.Get(data, "delta.type").String() == "input_json_delta" {
+				partialJSON.WriteString(gjson.Get(data, "delta.partial_json").String())
+			}
+		}
+	}
+
+	if starts != 1 || stops != 1 {
+		t.Errorf("Expected one tool_use block, got %d starts and %d stops", starts, stops)
+	}
+	args := partialJSON.String()
+	if !gjson.Valid(args) {
+		t.Fatalf("Expected accumulated partial_json to be a complete object, got %q", args)
+	}
+	if got := gjson.Get(args, "location").String(); got != "San Francisco" {
+		t.Errorf("Expected continued string argument to be joined, got %q", got)
+	}
+	if gjson.Get(args, "days").Int() != 3 || !gjson.Get(args, "alerts").Bool() || gjson.Get(args, "units.0").String() != "celsius" {
+		t.Errorf("Unexpected accumulated arguments: %s", args)
+	}
+	if !strings.Contains(output.String(), `"stop_reason":"tool_use"`) {
+		t.Errorf("Expected tool_use stop reason, got %s", output.String())
+	}
+}
+
+func TestConvertAntigravityResponseToClaude_InlineImage(t *testing.T) {
+	requestJSON := []byte(`{"model":"gemini-3-pro-image","messages":[{"role":"user","content":"Draw a dot"}]}`)
+	rawJSON := []byte(`{"response":{"responseId":"resp_1","candidates":[{"content":{"role":"model","parts":[
+		{"text":"Here is your image:"},
+		{"inlineData":{"mimeType":"image/png","data":"iVBORw0KGgo="}}
+	]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":4,"totalTokenCount":7}}}`)
+
+	nonStream := ConvertAntigravityResponseToClaudeNonStream(context.Background(), "", requestJSON, requestJSON, rawJSON, nil)
+	content := gjson.Get(nonStream, "content").Array()
+	if len(content) != 2 || content[0].Get("type").String() != "text" {
+		t.Fatalf("Expected text then image blocks, got %s", gjson.Get(nonStream, "content").Raw)
+	}
+	image := content[1]
+	if image.Get("type").String() != "image" || image.Get("source.type").String() != "base64" ||
+		image.Get("source.media_type").String() != "image/png" || image.Get("source.data").String() != "iVBORw0KGgo=" {
+		t.Errorf("Unexpected image block %s", image.Raw)
+	}
+
+	var param any
+	var output strings.Builder
+	for _, out := range ConvertAntigravityResponseToClaude(context.Background(), "", requestJSON, requestJSON, rawJSON, &param) {
+		output.WriteString(out)
+	}
+	var blockTypes []string
+	for _, line := range strings.Split(output.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok && gjson.Get(data, "type").String() == "content_block_start" {
+			blockTypes = append(blockTypes, gjson.Get(data, "content_block.type").String())
+			if gjson.Get(data, "content_block.type").String() == "image" && gjson.Get(data, "content_block.source.data").String() != "iVBORw0KGgo=" {
+				t.Errorf("Expected streamed image data, got %s", data)
+			}
+		}
+	}
+	if strings.Join(blockTypes, ",") != "text,image" {
+		t.Errorf("Expected text and image blocks in the stream, got %v", blockTypes)
+	}
+}
@@ -5,7 +5,9 @@ package chat_completions
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/misc"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/translator/gemini/common"
@@ -17,6 +19,53 @@ import (
 
 const geminiCLIFunctionThoughtSignature = "skip_thought_signature_validator"
 
+// Instruction orders control how system and developer messages combine into systemInstruction.
+const (
+	// InstructionOrderMessages keeps system and developer messages in the order they were sent.
+	InstructionOrderMessages = "messages"
+	// InstructionOrderSystemFirst places all system messages before developer messages.
+	InstructionOrderSystemFirst = "system-first"
+	// InstructionOrderDeveloperFirst places all developer messages before system messages.
+	InstructionOrderDeveloperFirst = "developer-first"
+)
+
+var instructionOrder atomic.Value // string
+
+// SetInstructionOrder selects how system and developer messages are combined into the
+// systemInstruction. Unknown or empty values fall back to InstructionOrderMessages.
+func SetInstructionOrder(order string) {
+	switch order {
+	case InstructionOrderSystemFirst, InstructionOrderDeveloperFirst:
+	default:
+		order = InstructionOrderMessages
+	}
+	instructionOrder.Store(order)
+}
+
+type instructionText struct {
+	role string
+	text string
+}
+
+// orderInstructions applies the configured order; the sort is stable so messages of the
+// same role keep their relative order.
+func orderInstructions(instructions []instructionText) []instructionText {
+	order, _ := instructionOrder.Load().(string)
+	first := ""
+	switch order {
+	case InstructionOrderSystemFirst:
+		first = "system"
+	case InstructionOrderDeveloperFirst:
+		first = "developer"
+	default:
+		return instructions
+	}
+	sort.SliceStable(instructions, func(i, j int) bool {
+		return instructions[i].role == first && instructions[j].role != first
+	})
+	return instructions
+}
+
 // ConvertOpenAIRequestToAntigravity converts an OpenAI Chat Completions request (raw JSON)
 // into a complete Gemini CLI request JSON. All JSON construction uses sjson and lookups use gjson.
 //
@@ -139,30 +188,21 @@ func ConvertOpenAIRequestToAntigravity(modelName string, inputRawJSON []byte, _
 			}
 		}
 
-		systemPartIndex := 0
+		var instructions []instructionText
 		for i := 0; i < len(arr); i++ {
 			m := arr[i]
 			role := m.Get("role").String()
 			content := m.Get("content")
 
 			if (role == "system" || role == "developer") && len(arr) > 1 {
-				// system -> request.systemInstruction as a user message style
+				// system/developer -> request.systemInstruction as a user message style, written after the loop
 				if content.Type == gjson.String {
-					out, _ = sjson.SetBytes(out, "request.systemInstruction.role", "user")
-					out, _ = sjson.SetBytes(out, fmt.Sprintf("request.systemInstruction.parts.%d.text", systemPartIndex), content.String())
-					systemPartIndex++
+					instructions = append(instructions, instructionText{role: role, text: content.String()})
 				} else if content.IsObject() && content.Get("type").String() == "text" {
-					out, _ = sjson.SetBytes(out, "request.systemInstruction.role", "user")
-					out, _ = sjson.SetBytes(out, fmt.Sprintf("request.systemInstruction.parts.%d.text", systemPartIndex), content.Get("text").String())
-					systemPartIndex++
+					instructions = append(instructions, instructionText{role: role, text: content.Get("text").String()})
 				} else if content.IsArray() {
-					contents := content.Array()
-					if len(contents) > 0 {
-						out, _ = sjson.SetBytes(out, "request.systemInstruction.role", "user")
-						for j := 0; j < len(contents); j++ {
-							out, _ = sjson.SetBytes(out, fmt.Sprintf("request.systemInstruction.parts.%d.text", systemPartIndex), contents[j].Get("text").String())
-							systemPartIndex++
-						}
+					for _, item := range content.Array() {
+						instructions = append(instructions, instructionText{role: role, text: item.Get("text").String()})
 					}
 				}
 			} else if role == "user" || ((role == "system" || role == "developer") && len(arr) == 1) {
@@ -303,6 +343,11 @@ func ConvertOpenAIRequestToAntigravity(modelName string, inputRawJSON []byte, _
 				}
 			}
 		}
+
+		for idx, instruction := range orderInstructions(instructions) {
+			out, _ = sjson.SetBytes(out, "request.systemInstruction.role", "user")
+			out, _ = sjson.SetBytes(out, fmt.Sprintf("request.systemInstruction.parts.%d.text", idx), instruction.text)
+		}
 	}
 
 	// tools -> request.tools[0].functionDeclarations + request.tools[0].googleSearch passthrough
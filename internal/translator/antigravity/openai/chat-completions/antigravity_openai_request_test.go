@@ -0,0 +1,51 @@
+package chat_completions
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestConvertOpenAIRequestToAntigravity_InstructionOrder(t *testing.T) {
+	t.Cleanup(func() { SetInstructionOrder("") })
+
+	inputJSON := []byte(`{
+		"model": "gemini-2.5-pro",
+		"messages": [
+			{"role": "developer", "content": "developer A"},
+			{"role": "system", "content": "system A"},
+			{"role": "developer", "content": "developer B"},
+			{"role": "user", "content": "Hi"}
+		]
+	}`)
+
+	tests := []struct {
+		order    string
+		expected []string
+	}{
+		{"", []string{"developer A", "system A", "developer B"}},
+		{InstructionOrderSystemFirst, []string{"system A", "developer A", "developer B"}},
+		{InstructionOrderDeveloperFirst, []string{"developer A", "developer B", "system A"}},
+	}
+
+	for _, tt := range tests {
+		SetInstructionOrder(tt.order)
+		output := ConvertOpenAIRequestToAntigravity("gemini-2.5-pro", inputJSON, false)
+
+		if role := gjson.GetBytes(output, "request.systemInstruction.role").String(); role != "user" {
+			t.Errorf("order %q: expected systemInstruction role user, got %q", tt.order, role)
+		}
+		parts := gjson.GetBytes(output, "request.systemInstruction.parts").Array()
+		if len(parts) != len(tt.expected) {
+			t.Fatalf("order %q: expected %d parts, got %d", tt.order, len(tt.expected), len(parts))
+		}
+		for i, want := range tt.expected {
+			if got := parts[i].Get("text").String(); got != want {
+				t.Errorf("order %q: part %d expected %q, got %q", tt.order, i, want, got)
+			}
+		}
+		if got := gjson.GetBytes(output, "request.contents.0.parts.0.text").String(); got != "Hi" {
+			t.Errorf("order %q: expected user content preserved, got %q", tt.order, got)
+		}
+	}
+}
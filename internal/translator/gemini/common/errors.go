@@ -0,0 +1,107 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// claudeErrorTypesByStatus maps Google RPC status names to Claude error types.
+var claudeErrorTypesByStatus = map[string]string{
+	"INVALID_ARGUMENT":    "invalid_request_error",
+	"FAILED_PRECONDITION": "invalid_request_error",
+	"OUT_OF_RANGE":        "invalid_request_error",
+	"UNAUTHENTICATED":     "authentication_error",
+	"PERMISSION_DENIED":   "permission_error",
+	"NOT_FOUND":           "not_found_error",
+	"RESOURCE_EXHAUSTED":  "rate_limit_error",
+	"UNAVAILABLE":         "overloaded_error",
+	"INTERNAL":            "api_error",
+	"DEADLINE_EXCEEDED":   "api_error",
+}
+
+// ConvertGeminiErrorToClaude rewrites a Gemini error body into Claude's
+// {"type":"error","error":{"type","message"}} shape. It understands the Google RPC error
+// object ({"error":{"code","message","status"}}, optionally wrapped in an array) and prompts
+// rejected by safety filters (promptFeedback.blockReason, optionally under "response").
+// ok is false when body is neither, e.g. when it already is a Claude error.
+func ConvertGeminiErrorToClaude(statusCode int, body []byte) (out []byte, ok bool) {
+	trimmed := bytes.TrimSpace(body)
+	if !gjson.ValidBytes(trimmed) {
+		return nil, false
+	}
+	root := gjson.ParseBytes(trimmed)
+	if root.IsArray() {
+		root = root.Get("0")
+	}
+
+	if blockReason := safetyBlockReason(root); blockReason != "" {
+		message := "Request blocked by Gemini safety filters: " + blockReason
+		if detail := root.Get("promptFeedback.blockReasonMessage").String(); detail != "" {
+			message += " (" + detail + ")"
+		}
+		return claudeError("invalid_request_error", message), true
+	}
+
+	errResult := root.Get("error")
+	if !errResult.IsObject() || root.Get("type").String() == "error" {
+		return nil, false
+	}
+	status := errResult.Get("status")
+	code := errResult.Get("code")
+	if status.Type != gjson.String && code.Type != gjson.Number {
+		return nil, false
+	}
+
+	errType, known := claudeErrorTypesByStatus[status.String()]
+	if !known {
+		if code.Type == gjson.Number && code.Int() > 0 {
+			statusCode = int(code.Int())
+		}
+		errType = claudeErrorTypeForHTTPStatus(statusCode)
+	}
+	message := errResult.Get("message").String()
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return claudeError(errType, message), true
+}
+
+// safetyBlockReason returns the prompt block reason of a Gemini or Antigravity response.
+func safetyBlockReason(root gjson.Result) string {
+	if reason := root.Get("promptFeedback.blockReason").String(); reason != "" {
+		return reason
+	}
+	return root.Get("response.promptFeedback.blockReason").String()
+}
+
+// claudeErrorTypeForHTTPStatus maps an HTTP status to the closest Claude error type.
+func claudeErrorTypeForHTTPStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+func claudeError(errType, message string) []byte {
+	out := []byte(`{"type":"error","error":{"type":"","message":""}}`)
+	out, _ = sjson.SetBytes(out, "error.type", errType)
+	out, _ = sjson.SetBytes(out, "error.message", message)
+	return out
+}
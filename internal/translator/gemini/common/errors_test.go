@@ -0,0 +1,59 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestConvertGeminiErrorToClaude_QuotaError(t *testing.T) {
+	body := []byte(`{"error":{"code":429,"message":"Resource has been exhausted (e.g. check quota).","status":"RESOURCE_EXHAUSTED"}}`)
+
+	out, ok := ConvertGeminiErrorToClaude(http.StatusTooManyRequests, body)
+	if !ok {
+		t.Fatal("Expected Gemini quota error to be converted")
+	}
+	if got := gjson.GetBytes(out, "type").String(); got != "error" {
+		t.Errorf("Expected top-level type 'error', got %q", got)
+	}
+	if got := gjson.GetBytes(out, "error.type").String(); got != "rate_limit_error" {
+		t.Errorf("Expected rate_limit_error, got %q", got)
+	}
+	if got := gjson.GetBytes(out, "error.message").String(); got != "Resource has been exhausted (e.g. check quota)." {
+		t.Errorf("Expected upstream message to be kept, got %q", got)
+	}
+}
+
+func TestConvertGeminiErrorToClaude_SafetyBlock(t *testing.T) {
+	body := []byte(`{"response":{"promptFeedback":{"blockReason":"SAFETY","safetyRatings":[]}}}`)
+
+	out, ok := ConvertGeminiErrorToClaude(http.StatusOK, body)
+	if !ok {
+		t.Fatal("Expected safety block to be converted")
+	}
+	if got := gjson.GetBytes(out, "error.type").String(); got != "invalid_request_error" {
+		t.Errorf("Expected invalid_request_error, got %q", got)
+	}
+	if got := gjson.GetBytes(out, "error.message").String(); got != "Request blocked by Gemini safety filters: SAFETY" {
+		t.Errorf("Unexpected message %q", got)
+	}
+}
+
+func TestConvertGeminiErrorToClaude_Passthrough(t *testing.T) {
+	cases := map[string]string{
+		"claude error": `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+		"plain text":   `upstream connection reset`,
+	}
+	for name, body := range cases {
+		if out, ok := ConvertGeminiErrorToClaude(http.StatusInternalServerError, []byte(body)); ok {
+			t.Errorf("%s: expected no conversion, got %s", name, out)
+		}
+	}
+
+	// Unknown status names fall back to the error code.
+	out, ok := ConvertGeminiErrorToClaude(0, []byte(`[{"error":{"code":503,"message":"The model is overloaded."}}]`))
+	if !ok || gjson.GetBytes(out, "error.type").String() != "overloaded_error" {
+		t.Errorf("Expected array-wrapped 503 to map to overloaded_error, got %s", out)
+	}
+}
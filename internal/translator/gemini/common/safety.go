@@ -1,34 +1,89 @@
 package common
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
+// defaultSafetyCategories lists the Gemini harm categories in the order they are emitted.
+var defaultSafetyCategories = []string{
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+	"HARM_CATEGORY_CIVIC_INTEGRITY",
+}
+
+// defaultSafetyThresholds holds the built-in threshold for each category.
+var defaultSafetyThresholds = map[string]string{
+	"HARM_CATEGORY_HARASSMENT":        "OFF",
+	"HARM_CATEGORY_HATE_SPEECH":       "OFF",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": "OFF",
+	"HARM_CATEGORY_DANGEROUS_CONTENT": "OFF",
+	"HARM_CATEGORY_CIVIC_INTEGRITY":   "BLOCK_NONE",
+}
+
+// knownSafetyThresholds lists the threshold values accepted by Gemini.
+var knownSafetyThresholds = []string{
+	"HARM_BLOCK_THRESHOLD_UNSPECIFIED",
+	"BLOCK_LOW_AND_ABOVE",
+	"BLOCK_MEDIUM_AND_ABOVE",
+	"BLOCK_ONLY_HIGH",
+	"BLOCK_NONE",
+	"OFF",
+}
+
+// safetyThresholdOverrides holds the configured per-category thresholds applied on top of the defaults.
+var (
+	safetyThresholdOverrides   map[string]string
+	safetyThresholdOverridesMu sync.RWMutex
+)
+
+// ValidateSafetyThresholds checks that every category and threshold in the map is known to Gemini.
+// Keys and values are matched case-insensitively; the returned map holds the normalized upper-case form.
+func ValidateSafetyThresholds(thresholds map[string]string) (map[string]string, error) {
+	if len(thresholds) == 0 {
+		return nil, nil
+	}
+	normalized := make(map[string]string, len(thresholds))
+	for category, threshold := range thresholds {
+		c := strings.ToUpper(strings.TrimSpace(category))
+		t := strings.ToUpper(strings.TrimSpace(threshold))
+		if _, ok := defaultSafetyThresholds[c]; !ok {
+			return nil, fmt.Errorf("unknown safety category %q (supported: %s)", category, strings.Join(defaultSafetyCategories, ", "))
+		}
+		if !containsString(knownSafetyThresholds, t) {
+			return nil, fmt.Errorf("unknown safety threshold %q for category %s (supported: %s)", threshold, c, strings.Join(knownSafetyThresholds, ", "))
+		}
+		normalized[c] = t
+	}
+	return normalized, nil
+}
+
+// SetSafetyThresholds replaces the configured per-category thresholds used by DefaultSafetySettings.
+// The existing configuration is kept when validation fails. Passing an empty map restores the defaults.
+func SetSafetyThresholds(thresholds map[string]string) error {
+	normalized, err := ValidateSafetyThresholds(thresholds)
+	if err != nil {
+		return err
+	}
+	safetyThresholdOverridesMu.Lock()
+	safetyThresholdOverrides = normalized
+	safetyThresholdOverridesMu.Unlock()
+	return nil
+}
+
 // DefaultSafetySettings returns the default Gemini safety configuration we attach to requests.
+// Thresholds configured via SetSafetyThresholds replace the built-in value for their category.
 func DefaultSafetySettings() []map[string]string {
-	return []map[string]string{
-		{
-			"category":  "HARM_CATEGORY_HARASSMENT",
-			"threshold": "OFF",
-		},
-		{
-			"category":  "HARM_CATEGORY_HATE_SPEECH",
-			"threshold": "OFF",
-		},
-		{
-			"category":  "HARM_CATEGORY_SEXUALLY_EXPLICIT",
-			"threshold": "OFF",
-		},
-		{
-			"category":  "HARM_CATEGORY_DANGEROUS_CONTENT",
-			"threshold": "OFF",
-		},
-		{
-			"category":  "HARM_CATEGORY_CIVIC_INTEGRITY",
-			"threshold": "BLOCK_NONE",
-		},
-	}
+	safetyThresholdOverridesMu.RLock()
+	overrides := safetyThresholdOverrides
+	safetyThresholdOverridesMu.RUnlock()
+	return buildSafetySettings(overrides)
 }
 
 // AttachDefaultSafetySettings ensures the default safety settings are present when absent.
@@ -45,3 +100,58 @@ func AttachDefaultSafetySettings(rawJSON []byte, path string) []byte {
 
 	return out
 }
+
+// AttachSafetySettingsOverConfigured behaves like AttachDefaultSafetySettings but applies the given
+// per-category thresholds on top of the configured ones, e.g. for per-request preferences.
+// An error is returned for unknown categories or thresholds and rawJSON is left unchanged.
+func AttachSafetySettingsOverConfigured(rawJSON []byte, path string, thresholds map[string]string) ([]byte, error) {
+	if gjson.GetBytes(rawJSON, path).Exists() {
+		return rawJSON, nil
+	}
+
+	normalized, err := ValidateSafetyThresholds(thresholds)
+	if err != nil {
+		return rawJSON, err
+	}
+
+	safetyThresholdOverridesMu.RLock()
+	merged := make(map[string]string, len(safetyThresholdOverrides)+len(normalized))
+	for category, threshold := range safetyThresholdOverrides {
+		merged[category] = threshold
+	}
+	safetyThresholdOverridesMu.RUnlock()
+	for category, threshold := range normalized {
+		merged[category] = threshold
+	}
+
+	out, err := sjson.SetBytes(rawJSON, path, buildSafetySettings(merged))
+	if err != nil {
+		return rawJSON, err
+	}
+
+	return out, nil
+}
+
+func buildSafetySettings(overrides map[string]string) []map[string]string {
+	settings := make([]map[string]string, 0, len(defaultSafetyCategories))
+	for _, category := range defaultSafetyCategories {
+		threshold := defaultSafetyThresholds[category]
+		if override, ok := overrides[category]; ok {
+			threshold = override
+		}
+		settings = append(settings, map[string]string{
+			"category":  category,
+			"threshold": threshold,
+		})
+	}
+	return settings
+}
+
+func containsString(items []string, item string) bool {
+	for _, s := range items {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestAttachSafetySettingsOverConfigured_MixedThresholds(t *testing.T) {
+	thresholds := map[string]string{
+		"HARM_CATEGORY_HARASSMENT":        "BLOCK_NONE",
+		"harm_category_dangerous_content": "block_medium_and_above",
+	}
+
+	out, err := AttachSafetySettingsOverConfigured([]byte(`{"request":{}}`), "request.safetySettings", thresholds)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"HARM_CATEGORY_HARASSMENT":        "BLOCK_NONE",
+		"HARM_CATEGORY_HATE_SPEECH":       "OFF",
+		"HARM_CATEGORY_SEXUALLY_EXPLICIT": "OFF",
+		"HARM_CATEGORY_DANGEROUS_CONTENT": "BLOCK_MEDIUM_AND_ABOVE",
+		"HARM_CATEGORY_CIVIC_INTEGRITY":   "BLOCK_NONE",
+	}
+	settings := gjson.GetBytes(out, "request.safetySettings").Array()
+	if len(settings) != len(expected) {
+		t.Fatalf("Expected %d safety settings, got %d", len(expected), len(settings))
+	}
+	for _, s := range settings {
+		category := s.Get("category").String()
+		if got := s.Get("threshold").String(); got != expected[category] {
+			t.Errorf("Category %s: expected threshold %s, got %s", category, expected[category], got)
+		}
+	}
+}
+
+func TestValidateSafetyThresholds_UnknownRejected(t *testing.T) {
+	_, err := ValidateSafetyThresholds(map[string]string{
+		"HARM_CATEGORY_UNKNOWN": "BLOCK_NONE",
+	})
+	if err == nil || !strings.Contains(err.Error(), "HARM_CATEGORY_UNKNOWN") {
+		t.Fatalf("Expected unknown category error, got %v", err)
+	}
+
+	_, err = ValidateSafetyThresholds(map[string]string{
+		"HARM_CATEGORY_HARASSMENT": "BLOCK_SOMETIMES",
+	})
+	if err == nil || !strings.Contains(err.Error(), "BLOCK_SOMETIMES") {
+		t.Fatalf("Expected unknown threshold error, got %v", err)
+	}
+}
+
+func TestSetSafetyThresholds_AppliesToDefaults(t *testing.T) {
+	t.Cleanup(func() { _ = SetSafetyThresholds(nil) })
+
+	if err := SetSafetyThresholds(map[string]string{"HARM_CATEGORY_HATE_SPEECH": "BLOCK_ONLY_HIGH"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := AttachDefaultSafetySettings([]byte(`{}`), "safetySettings")
+	if got := gjson.GetBytes(out, `safetySettings.#(category=="HARM_CATEGORY_HATE_SPEECH").threshold`).String(); got != "BLOCK_ONLY_HIGH" {
+		t.Errorf("Expected configured threshold BLOCK_ONLY_HIGH, got %s", got)
+	}
+	if got := gjson.GetBytes(out, `safetySettings.#(category=="HARM_CATEGORY_HARASSMENT").threshold`).String(); got != "OFF" {
+		t.Errorf("Expected default threshold OFF, got %s", got)
+	}
+
+	// Invalid configuration keeps the previous overrides.
+	if err := SetSafetyThresholds(map[string]string{"HARM_CATEGORY_BOGUS": "OFF"}); err == nil {
+		t.Fatal("Expected error for unknown category")
+	}
+	out = AttachDefaultSafetySettings([]byte(`{}`), "safetySettings")
+	if got := gjson.GetBytes(out, `safetySettings.#(category=="HARM_CATEGORY_HATE_SPEECH").threshold`).String(); got != "BLOCK_ONLY_HIGH" {
+		t.Errorf("Expected previous threshold to be kept, got %s", got)
+	}
+}
+
+func TestAttachSafetySettingsOverConfigured(t *testing.T) {
+	t.Cleanup(func() { _ = SetSafetyThresholds(nil) })
+	if err := SetSafetyThresholds(map[string]string{
+		"HARM_CATEGORY_HARASSMENT":  "BLOCK_ONLY_HIGH",
+		"HARM_CATEGORY_HATE_SPEECH": "BLOCK_ONLY_HIGH",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, err := AttachSafetySettingsOverConfigured([]byte(`{}`), "safetySettings", map[string]string{
+		"harm_category_harassment": "block_low_and_above",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := gjson.GetBytes(out, `safetySettings.#(category=="HARM_CATEGORY_HARASSMENT").threshold`).String(); got != "BLOCK_LOW_AND_ABOVE" {
+		t.Errorf("Expected request threshold to take precedence, got %s", got)
+	}
+	if got := gjson.GetBytes(out, `safetySettings.#(category=="HARM_CATEGORY_HATE_SPEECH").threshold`).String(); got != "BLOCK_ONLY_HIGH" {
+		t.Errorf("Expected configured threshold to be kept, got %s", got)
+	}
+
+	if _, err = AttachSafetySettingsOverConfigured([]byte(`{}`), "safetySettings", map[string]string{"HARM_CATEGORY_NOPE": "OFF"}); err == nil {
+		t.Error("Expected error for unknown category")
+	}
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+)
+
+// cacheResetters holds the reset functions registered by packages that keep their own caches.
+var (
+	cacheResetters   []*func()
+	cacheResettersMu sync.Mutex
+)
+
+// RegisterCacheReset adds reset to the functions run by ResetClientCaches and ResetCaches and
+// returns a function that removes it again. Packages that keep package-level caches (such as
+// cached HTTP clients) register from init().
+func RegisterCacheReset(reset func()) (unregister func()) {
+	if reset == nil {
+		return func() {}
+	}
+	entry := &reset
+	cacheResettersMu.Lock()
+	cacheResetters = append(cacheResetters, entry)
+	cacheResettersMu.Unlock()
+	return func() {
+		cacheResettersMu.Lock()
+		defer cacheResettersMu.Unlock()
+		for i, registered := range cacheResetters {
+			if registered == entry {
+				cacheResetters = append(cacheResetters[:i], cacheResetters[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// ResetClientCaches runs every registered reset function, dropping state such as HTTP clients
+// bound to a previous configuration. It leaves the thinking signature cache alone, so it is safe
+// to call on config reloads without breaking conversations in flight.
+func ResetClientCaches() {
+	cacheResettersMu.Lock()
+	resetters := append([]*func(){}, cacheResetters...)
+	cacheResettersMu.Unlock()
+	for _, reset := range resetters {
+		(*reset)()
+	}
+}
+
+// ResetCaches clears the thinking signature cache and every registered cache. It is meant for
+// tests; reloads should use ResetClientCaches.
+func ResetCaches() {
+	cache.ClearSignatureCache("")
+	ResetClientCaches()
+}
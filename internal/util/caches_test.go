@@ -0,0 +1,45 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/cache"
+)
+
+func TestResetCaches(t *testing.T) {
+	signature := "abc123validSignature1234567890123456789012345678901234567890"
+	cache.CacheSignature("claude-sonnet-4-5", "some thinking", signature)
+	if cache.GetCachedSignature("claude-sonnet-4-5", "some thinking") != signature {
+		t.Fatal("Expected signature to be cached before reset")
+	}
+
+	entries := map[string]int{"client": 1}
+	t.Cleanup(RegisterCacheReset(func() { clear(entries) }))
+
+	ResetCaches()
+
+	if got := cache.GetCachedSignature("claude-sonnet-4-5", "some thinking"); got != "" {
+		t.Errorf("Expected signature cache cleared, got %q", got)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected registered cache cleared, got %v", entries)
+	}
+}
+
+func TestResetClientCachesKeepsSignatures(t *testing.T) {
+	signature := "abc123validSignature1234567890123456789012345678901234567890"
+	cache.CacheSignature("claude-sonnet-4-5", "reload thinking", signature)
+	t.Cleanup(func() { cache.ClearSignatureCache("") })
+
+	entries := map[string]int{"client": 1}
+	t.Cleanup(RegisterCacheReset(func() { clear(entries) }))
+
+	ResetClientCaches()
+
+	if len(entries) != 0 {
+		t.Errorf("Expected registered cache cleared, got %v", entries)
+	}
+	if got := cache.GetCachedSignature("claude-sonnet-4-5", "reload thinking"); got != signature {
+		t.Errorf("Expected signature cache kept, got %q", got)
+	}
+}
@@ -6,16 +6,35 @@ import (
 	"sort"
 	"strings"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
 var gjsonPathKeyReplacer = strings.NewReplacer(".", "\\.", "*", "\\*", "?", "\\?")
 
+// SchemaSanitizer cleans a JSON schema so it is accepted by an upstream API.
+type SchemaSanitizer interface {
+	Clean(jsonStr string) string
+}
+
+// SchemaSanitizerFunc adapts a plain function to the SchemaSanitizer interface.
+type SchemaSanitizerFunc func(jsonStr string) string
+
+// Clean calls f(jsonStr).
+func (f SchemaSanitizerFunc) Clean(jsonStr string) string { return f(jsonStr) }
+
+// AntigravitySchemaSanitizer is the default sanitizer backed by CleanJSONSchemaForAntigravity.
+var AntigravitySchemaSanitizer SchemaSanitizer = SchemaSanitizerFunc(CleanJSONSchemaForAntigravity)
+
 // CleanJSONSchemaForAntigravity transforms a JSON schema to be compatible with Antigravity API.
 // It handles unsupported keywords, type flattening, and schema simplification while preserving
-// semantic information as description hints.
+// semantic information as description hints. Input that is not valid JSON is returned unchanged.
 func CleanJSONSchemaForAntigravity(jsonStr string) string {
+	if !gjson.Valid(jsonStr) {
+		return jsonStr
+	}
+
 	// Phase 1: Convert and add hints
 	jsonStr = convertRefsToHints(jsonStr)
 	jsonStr = convertConstToEnum(jsonStr)
@@ -39,6 +58,123 @@ func CleanJSONSchemaForAntigravity(jsonStr string) string {
 	return jsonStr
 }
 
+// GeminiNativeSchemaMarker is the vendor keyword a client sets to true on a schema that is
+// already Gemini-compatible and must not be rewritten by CleanJSONSchemaForAntigravity.
+const GeminiNativeSchemaMarker = "x-gemini-native"
+
+// IsGeminiNativeSchema reports whether a schema was authored for Gemini: either it carries
+// GeminiNativeSchemaMarker at the root, or it uses propertyOrdering, a Gemini-only keyword.
+func IsGeminiNativeSchema(jsonStr string) bool {
+	if gjson.Get(jsonStr, GeminiNativeSchemaMarker).Bool() {
+		return true
+	}
+	for _, p := range findPaths(jsonStr, "propertyOrdering") {
+		if !isPropertyDefinition(trimSuffix(p, ".propertyOrdering")) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripGeminiNativeMarker removes GeminiNativeSchemaMarker from the schema root, leaving the
+// rest of the schema untouched.
+func StripGeminiNativeMarker(jsonStr string) string {
+	if !gjson.Get(jsonStr, GeminiNativeSchemaMarker).Exists() {
+		return jsonStr
+	}
+	result, _ := sjson.Delete(jsonStr, GeminiNativeSchemaMarker)
+	return result
+}
+
+// maxRefDepth bounds how many levels of nested references ResolveSchemaRefs expands, which also
+// stops recursive definitions from growing without limit.
+const maxRefDepth = 5
+
+// ResolveSchemaRefs inlines local "#/$defs/Name" and "#/definitions/Name" references in a schema
+// using defs, a JSON object of shared definitions (e.g. declared once at the request root).
+// Definitions declared inside the schema itself take precedence over defs. Sibling keywords next
+// to a $ref (such as description) win over the referenced definition's. References that cannot be
+// resolved or nest deeper than maxRefDepth are left in place for CleanJSONSchemaForAntigravity to
+// turn into hints.
+func ResolveSchemaRefs(jsonStr, defs string) string {
+	definitions := make(map[string]string)
+	collect := func(obj gjson.Result) {
+		obj.ForEach(func(key, value gjson.Result) bool {
+			if value.IsObject() {
+				definitions[key.String()] = value.Raw
+			}
+			return true
+		})
+	}
+	collect(gjson.Parse(defs))
+	collect(gjson.Get(jsonStr, "definitions"))
+	collect(gjson.Get(jsonStr, "$defs"))
+	if len(definitions) == 0 {
+		return jsonStr
+	}
+
+	for depth := 0; depth < maxRefDepth; depth++ {
+		paths := findPaths(jsonStr, "$ref")
+		sortByDepth(paths)
+		changed := false
+		for _, p := range paths {
+			parentPath := trimSuffix(p, ".$ref")
+			if isPropertyDefinition(parentPath) || isInsideDefinitions(parentPath) {
+				continue
+			}
+			ref := gjson.Get(jsonStr, p)
+			if ref.Type != gjson.String {
+				continue
+			}
+			name, ok := localDefinitionName(ref.String())
+			if !ok {
+				continue
+			}
+			definition, ok := definitions[name]
+			if !ok {
+				continue
+			}
+
+			parent := gjson.Parse(jsonStr)
+			if parentPath != "" {
+				parent = gjson.Get(jsonStr, parentPath)
+			}
+			resolved, _ := sjson.Delete(parent.Raw, "$ref")
+			gjson.Parse(definition).ForEach(func(key, value gjson.Result) bool {
+				escapedKey := escapeGJSONPathKey(key.String())
+				if !gjson.Get(resolved, escapedKey).Exists() {
+					resolved, _ = sjson.SetRaw(resolved, escapedKey, value.Raw)
+				}
+				return true
+			})
+			jsonStr = setRawAt(jsonStr, parentPath, resolved)
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+	return jsonStr
+}
+
+func localDefinitionName(ref string) (string, bool) {
+	for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok && name != "" && !strings.Contains(name, "/") {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func isInsideDefinitions(path string) bool {
+	for _, part := range splitGJSONPath(path) {
+		if part == "$defs" || part == "definitions" {
+			return true
+		}
+	}
+	return false
+}
+
 // convertRefsToHints converts $ref to description hints (Lazy Hint strategy).
 func convertRefsToHints(jsonStr string) string {
 	paths := findPaths(jsonStr, "$ref")
@@ -94,7 +230,7 @@ func convertEnumValuesToStrings(jsonStr string) string {
 			if item.Type != gjson.String {
 				needsConversion = true
 			}
-			stringVals = append(stringVals, item.String())
+			stringVals = append(stringVals, enumValueString(item))
 		}
 
 		// Only update if we found non-string values
@@ -105,6 +241,15 @@ func convertEnumValuesToStrings(jsonStr string) string {
 	return jsonStr
 }
 
+// enumValueString renders an enum value as a string. Objects and arrays are emitted as
+// compact JSON so equal values always produce the same string regardless of input formatting.
+func enumValueString(item gjson.Result) string {
+	if item.IsObject() || item.IsArray() {
+		return gjson.Get(item.Raw, "@ugly").Raw
+	}
+	return item.String()
+}
+
 func addEnumHints(jsonStr string) string {
 	for _, p := range findPaths(jsonStr, "enum") {
 		arr := gjson.Get(jsonStr, p)
@@ -118,7 +263,7 @@ func addEnumHints(jsonStr string) string {
 
 		var vals []string
 		for _, item := range items {
-			vals = append(vals, item.String())
+			vals = append(vals, enumValueString(item))
 		}
 		jsonStr = appendHint(jsonStr, trimSuffix(p, ".enum"), "Allowed: "+strings.Join(vals, ", "))
 	}
@@ -127,8 +272,12 @@ func addEnumHints(jsonStr string) string {
 
 func addAdditionalPropertiesHints(jsonStr string) string {
 	for _, p := range findPaths(jsonStr, "additionalProperties") {
-		if gjson.Get(jsonStr, p).Type == gjson.False {
+		switch gjson.Get(jsonStr, p).Type {
+		case gjson.False:
 			jsonStr = appendHint(jsonStr, trimSuffix(p, ".additionalProperties"), "No extra properties allowed")
+		case gjson.True:
+			// Free-form objects: keep the permission visible once the keyword is removed.
+			jsonStr = appendHint(jsonStr, trimSuffix(p, ".additionalProperties"), "Extra properties allowed")
 		}
 	}
 	return jsonStr
@@ -178,9 +327,9 @@ func mergeAllOf(jsonStr string) string {
 			}
 			if req := item.Get("required"); req.IsArray() {
 				reqPath := joinPath(parentPath, "required")
-				current := getStrings(jsonStr, reqPath)
-				for _, r := range req.Array() {
-					if s := r.String(); !contains(current, s) {
+				current := requiredNames(gjson.Get(jsonStr, reqPath), reqPath)
+				for _, s := range requiredNames(req, p) {
+					if !contains(current, s) {
 						current = append(current, s)
 					}
 				}
@@ -260,13 +409,20 @@ func flattenTypeArrays(jsonStr string) string {
 
 	for _, p := range paths {
 		res := gjson.Get(jsonStr, p)
-		if !res.IsArray() || len(res.Array()) == 0 {
+		var types []gjson.Result
+		if res.IsArray() {
+			types = res.Array()
+		} else if res.Type == gjson.String && res.String() == "null" {
+			// Gemini has no null type; a pure-null node becomes a nullable string placeholder
+			types = []gjson.Result{res}
+		}
+		if len(types) == 0 {
 			continue
 		}
 
 		hasNull := false
 		var nonNullTypes []string
-		for _, item := range res.Array() {
+		for _, item := range types {
 			s := item.String()
 			if s == "null" {
 				hasNull = true
@@ -310,9 +466,9 @@ func flattenTypeArrays(jsonStr string) string {
 		}
 
 		var filtered []string
-		for _, r := range req.Array() {
-			if !contains(fields, r.String()) {
-				filtered = append(filtered, r.String())
+		for _, name := range requiredNames(req, reqPath) {
+			if !contains(fields, name) {
+				filtered = append(filtered, name)
 			}
 		}
 
@@ -347,15 +503,14 @@ func cleanupRequiredFields(jsonStr string) string {
 		propsPath := joinPath(parentPath, "properties")
 
 		req := gjson.Get(jsonStr, p)
-		props := gjson.Get(jsonStr, propsPath)
-		if !req.IsArray() || !props.IsObject() {
+		if !req.IsArray() {
 			continue
 		}
+		props := gjson.Get(jsonStr, propsPath)
 
 		var valid []string
-		for _, r := range req.Array() {
-			key := r.String()
-			if props.Get(escapeGJSONPathKey(key)).Exists() {
+		for _, key := range requiredNames(req, p) {
+			if !props.IsObject() || props.Get(escapeGJSONPathKey(key)).Exists() {
 				valid = append(valid, key)
 			}
 		}
@@ -501,14 +656,18 @@ func appendHintRaw(jsonRaw, hint string) string {
 	return jsonRaw
 }
 
-func getStrings(jsonStr, path string) []string {
-	var result []string
-	if arr := gjson.Get(jsonStr, path); arr.IsArray() {
-		for _, r := range arr.Array() {
-			result = append(result, r.String())
+// requiredNames returns the string entries of a "required" array. Any other entry is
+// malformed and dropped, since coercing it (e.g. 5 to "5") could match an unrelated property.
+func requiredNames(req gjson.Result, path string) []string {
+	var names []string
+	for _, r := range req.Array() {
+		if r.Type != gjson.String {
+			log.Debugf("schema: dropping non-string required entry %s at %s", r.Raw, path)
+			continue
 		}
+		names = append(names, r.String())
 	}
-	return result
+	return names
 }
 
 func contains(slice []string, item string) bool {
@@ -550,6 +550,29 @@ func TestCleanJSONSchemaForAntigravity_AdditionalPropertiesHint(t *testing.T) {
 	}
 }
 
+func TestCleanJSONSchemaForAntigravity_AdditionalPropertiesTrueHint(t *testing.T) {
+	input := `{
+		"type": "object",
+		"properties": {
+			"metadata": {
+				"type": "object",
+				"description": "Arbitrary labels",
+				"additionalProperties": true
+			}
+		}
+	}`
+
+	result := CleanJSONSchemaForAntigravity(input)
+
+	if gjson.Get(result, "properties.metadata.additionalProperties").Exists() {
+		t.Errorf("Expected additionalProperties to be removed, got: %s", result)
+	}
+	desc := gjson.Get(result, "properties.metadata.description").String()
+	if !strings.Contains(desc, "Extra properties allowed") || !strings.Contains(desc, "Arbitrary labels") {
+		t.Errorf("Expected extra properties hint appended to description, got %q", desc)
+	}
+}
+
 func TestCleanJSONSchemaForAntigravity_AnyOfFlattening_PreservesDescription(t *testing.T) {
 	input := `{
 		"type": "object",
@@ -869,3 +892,243 @@ func TestCleanJSONSchemaForAntigravity_BooleanEnumToString(t *testing.T) {
 		t.Errorf("Boolean enum values should be converted to string format, got: %s", result)
 	}
 }
+
+func TestCleanJSONSchemaForAntigravity_ObjectConstToJSONString(t *testing.T) {
+	// Object and array const/enum values must become JSON strings, not Go-formatted values
+	input := `{
+		"type": "object",
+		"properties": {
+			"point": {"const": {"x": 1, "y": [2, 3]}},
+			"shape": {"enum": [{"kind": "circle"}, ["a", "b"], "none"]}
+		}
+	}`
+
+	result := CleanJSONSchemaForAntigravity(input)
+
+	pointEnum := gjson.Get(result, "properties.point.enum").Array()
+	if len(pointEnum) != 1 || pointEnum[0].String() != `{"x":1,"y":[2,3]}` {
+		t.Errorf("Expected object const as compact JSON string, got: %s", result)
+	}
+	if !gjson.Valid(pointEnum[0].String()) {
+		t.Errorf("Expected const enum value to be valid JSON, got: %s", pointEnum[0].String())
+	}
+
+	shapeEnum := gjson.Get(result, "properties.shape.enum").Array()
+	expected := []string{`{"kind":"circle"}`, `["a","b"]`, "none"}
+	if len(shapeEnum) != len(expected) {
+		t.Fatalf("Expected %d enum values, got: %s", len(expected), result)
+	}
+	for i, want := range expected {
+		if shapeEnum[i].String() != want {
+			t.Errorf("Enum value %d: expected %s, got %s", i, want, shapeEnum[i].String())
+		}
+	}
+	if strings.Contains(result, "map[") {
+		t.Errorf("Enum values must not use Go formatting, got: %s", result)
+	}
+}
+
+func TestCleanJSONSchemaForAntigravity_NullableInsideArrayItems(t *testing.T) {
+	// Nullable tracking must work when the object lives under array items, including tuple-style indexed items
+	input := `{
+		"type": "object",
+		"properties": {
+			"entries": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"properties": {
+						"note": {"type": ["string", "null"]},
+						"id": {"type": "string"}
+					},
+					"required": ["note", "id"]
+				}
+			},
+			"pair": {
+				"type": "array",
+				"items": [
+					{
+						"type": "object",
+						"properties": {
+							"a": {"type": ["integer", "null"]},
+							"b": {"type": "integer"}
+						},
+						"required": ["a", "b"]
+					}
+				]
+			}
+		}
+	}`
+
+	result := CleanJSONSchemaForAntigravity(input)
+
+	required := gjson.Get(result, "properties.entries.items.required").Array()
+	if len(required) != 1 || required[0].String() != "id" {
+		t.Errorf("Expected nullable field removed from array item required, got: %s", result)
+	}
+	if !strings.Contains(gjson.Get(result, "properties.entries.items.properties.note.description").String(), "nullable") {
+		t.Errorf("Expected nullable hint on array item field, got: %s", result)
+	}
+
+	tupleRequired := gjson.Get(result, "properties.pair.items.0.required").Array()
+	if len(tupleRequired) != 1 || tupleRequired[0].String() != "b" {
+		t.Errorf("Expected nullable field removed from indexed item required, got: %s", result)
+	}
+}
+
+func TestCleanJSONSchemaForAntigravity_PureNullType(t *testing.T) {
+	input := `{
+		"type": "object",
+		"properties": {
+			"nothing": {"type": "null", "description": "Always null"},
+			"name": {"type": "string"}
+		},
+		"required": ["nothing", "name"]
+	}`
+
+	result := CleanJSONSchemaForAntigravity(input)
+
+	if got := gjson.Get(result, "properties.nothing.type").String(); got != "string" {
+		t.Errorf("Expected pure null type replaced with string, got: %s", result)
+	}
+	if desc := gjson.Get(result, "properties.nothing.description").String(); !strings.Contains(desc, "nullable") || !strings.Contains(desc, "Always null") {
+		t.Errorf("Expected nullable hint appended to description, got %q", desc)
+	}
+	required := gjson.Get(result, "required").Array()
+	if len(required) != 1 || required[0].String() != "name" {
+		t.Errorf("Expected null-only field removed from required, got: %s", result)
+	}
+	if strings.Contains(result, `"null"`) {
+		t.Errorf("Expected no null type left, got: %s", result)
+	}
+}
+
+func TestCleanJSONSchemaForAntigravity_NonStringRequiredEntries(t *testing.T) {
+	input := `{
+		"type": "object",
+		"properties": {
+			"5": {"type": "string"},
+			"name": {"type": "string"},
+			"age": {"type": "integer"}
+		},
+		"required": ["name", 5, null],
+		"allOf": [
+			{"required": ["age", {"bad": true}]}
+		]
+	}`
+
+	result := CleanJSONSchemaForAntigravity(input)
+
+	required := gjson.Get(result, "required").Array()
+	if len(required) != 2 || required[0].String() != "name" || required[1].String() != "age" {
+		t.Errorf("Expected only string required entries kept, got: %s", gjson.Get(result, "required").Raw)
+	}
+	for _, r := range required {
+		if r.Type != gjson.String {
+			t.Errorf("Expected string required entry, got %s", r.Raw)
+		}
+	}
+}
+
+func TestResolveSchemaRefs_SharedDefinitions(t *testing.T) {
+	defs := `{
+		"Address": {"type": "object", "properties": {"city": {"type": "string"}, "zip": {"$ref": "#/$defs/Zip"}}},
+		"Zip": {"type": "string", "description": "Postal code"}
+	}`
+	input := `{
+		"type": "object",
+		"properties": {
+			"home": {"$ref": "#/$defs/Address", "description": "Home address"},
+			"tags": {"type": "array", "items": {"$ref": "#/definitions/Tag"}},
+			"other": {"$ref": "#/$defs/Missing"}
+		},
+		"definitions": {"Tag": {"type": "string", "enum": ["a", "b"]}}
+	}`
+
+	result := ResolveSchemaRefs(input, defs)
+
+	if got := gjson.Get(result, "properties.home.properties.city.type").String(); got != "string" {
+		t.Errorf("Expected shared definition inlined, got: %s", result)
+	}
+	if got := gjson.Get(result, "properties.home.description").String(); got != "Home address" {
+		t.Errorf("Expected sibling description to win over definition, got %q", got)
+	}
+	if got := gjson.Get(result, "properties.home.properties.zip.description").String(); got != "Postal code" {
+		t.Errorf("Expected nested ref resolved, got: %s", result)
+	}
+	if got := gjson.Get(result, "properties.tags.items.enum.#").Int(); got != 2 {
+		t.Errorf("Expected schema-local definitions resolved, got: %s", result)
+	}
+	if got := gjson.Get(result, "properties.other.\\$ref").String(); got != "#/$defs/Missing" {
+		t.Errorf("Expected unresolved ref left in place, got: %s", result)
+	}
+}
+
+func TestResolveSchemaRefs_RecursiveDefinitionTerminates(t *testing.T) {
+	defs := `{"Node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/Node"}}}}`
+	input := `{"$ref": "#/$defs/Node"}`
+
+	result := CleanJSONSchemaForAntigravity(ResolveSchemaRefs(input, defs))
+
+	if got := gjson.Get(result, "type").String(); got != "object" {
+		t.Errorf("Expected root ref resolved, got: %s", result)
+	}
+	if strings.Contains(result, "$ref") {
+		t.Errorf("Expected remaining refs converted to hints, got: %s", result)
+	}
+}
+
+func TestIsGeminiNativeSchema(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		want   bool
+	}{
+		{"marker", `{"type":"object","x-gemini-native":true}`, true},
+		{"propertyOrdering", `{"type":"object","properties":{"a":{"type":"string"}},"propertyOrdering":["a"]}`, true},
+		{"property named propertyOrdering", `{"type":"object","properties":{"propertyOrdering":{"type":"string"}}}`, false},
+		{"plain", `{"type":"object","properties":{"a":{"type":"string"}}}`, false},
+	}
+	for _, tc := range cases {
+		if got := IsGeminiNativeSchema(tc.schema); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	if got := StripGeminiNativeMarker(`{"type":"object","x-gemini-native":true}`); got != `{"type":"object"}` {
+		t.Errorf("Expected marker stripped, got %s", got)
+	}
+}
+
+func FuzzCleanJSONSchema(f *testing.F) {
+	complexSchema := `{
+		"type": "object",
+		"$defs": {"node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/node"}}}},
+		"properties": {
+			"mode": {"const": "fast"},
+			"level": {"type": ["integer", "null"], "minimum": 1, "maximum": 5},
+			"tags": {"type": "array", "items": {"enum": [1, "two", true]}, "minItems": 1},
+			"choice": {"anyOf": [{"type": "string", "format": "email"}, {"type": "object", "additionalProperties": false}]},
+			"merged": {"allOf": [{"properties": {"a": {"type": "string"}}, "required": ["a"]}, {"required": ["b", 3]}]},
+			"node": {"$ref": "#/$defs/node"}
+		},
+		"required": ["mode", "missing"],
+		"additionalProperties": true
+	}`
+	for _, seed := range []string{complexSchema, `true`, `false`, `null`, `{}`, `{"type":"object","properties":{"x":true,"y":null}}`, `[`, `{"properties":`} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		output := CleanJSONSchemaForAntigravity(input)
+		if !gjson.Valid(input) {
+			if output != input {
+				t.Fatalf("cleaner rewrote invalid JSON\ninput:  %q\noutput: %q", input, output)
+			}
+			return
+		}
+		if !gjson.Valid(output) {
+			t.Fatalf("cleaner produced invalid JSON\ninput:  %s\noutput: %s", input, output)
+		}
+	})
+}
@@ -8,15 +8,114 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/proxy"
 )
 
+// proxyOverrideKey is the context key for a per-request proxy set by WithProxyURL.
+type proxyOverrideKey struct{}
+
+var directDialer = &net.Dialer{}
+
+// WithProxyURL returns a copy of ctx that routes requests made with it through proxyURL
+// instead of the client's configured proxy, so one shared client can serve tenants with
+// different egress proxies. SOCKS5, HTTP, and HTTPS proxy URLs are supported; an empty,
+// invalid, or unsupported URL leaves ctx unchanged. The override is honoured by transports
+// wrapped with HonorProxyOverride, which includes every client configured by SetProxy.
+func WithProxyURL(ctx context.Context, proxyURL string) context.Context {
+	if proxyURL == "" {
+		return ctx
+	}
+	parsed, errParse := url.Parse(proxyURL)
+	if errParse != nil || parsed.Host == "" {
+		log.Warnf("ignoring invalid per-request proxy URL %q", redactProxyURL(proxyURL))
+		return ctx
+	}
+	switch parsed.Scheme {
+	case "socks5", "http", "https":
+		return context.WithValue(ctx, proxyOverrideKey{}, parsed)
+	default:
+		log.Warnf("ignoring per-request proxy with unsupported scheme %q", parsed.Scheme)
+		return ctx
+	}
+}
+
+// proxyOverride returns the per-request proxy stored by WithProxyURL, or nil.
+func proxyOverride(ctx context.Context) *url.URL {
+	if ctx == nil {
+		return nil
+	}
+	override, _ := ctx.Value(proxyOverrideKey{}).(*url.URL)
+	return override
+}
+
+// redactProxyURL masks the userinfo of a proxy URL so credentials never reach the log.
+// It works on the raw string because it is also used for URLs that fail to parse.
+func redactProxyURL(proxyURL string) string {
+	scheme, rest := "", proxyURL
+	if i := strings.Index(proxyURL, "://"); i >= 0 {
+		scheme, rest = proxyURL[:i+3], proxyURL[i+3:]
+	}
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		return scheme + "xxxxx@" + rest[at+1:]
+	}
+	return proxyURL
+}
+
+// HonorProxyOverride makes transport route requests whose context carries a WithProxyURL
+// override through that proxy instead of its own proxy or dialer. Connections made through an
+// override are pooled separately, since the transport keys its idle pool by proxy. Requests
+// without an override keep using the transport's own Proxy, or its dialer when it has none.
+func HonorProxyOverride(transport *http.Transport) *http.Transport {
+	baseProxy := transport.Proxy
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if override := proxyOverride(req.Context()); override != nil {
+			return override, nil
+		}
+		if baseProxy == nil {
+			return nil, nil
+		}
+		return baseProxy(req)
+	}
+	if baseDial := transport.DialContext; baseDial != nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if proxyOverride(ctx) != nil {
+				// The transport is dialing the override proxy itself.
+				return directDialer.DialContext(ctx, network, addr)
+			}
+			return baseDial(ctx, network, addr)
+		}
+	}
+	return transport
+}
+
+// directTransport is shared by every client without a configured proxy so they pool connections.
+var (
+	directTransport     *http.Transport
+	directTransportOnce sync.Once
+)
+
+// DirectTransport returns the shared transport for clients without a configured proxy. Like
+// http.DefaultTransport it uses the proxy from HTTP_PROXY, HTTPS_PROXY and NO_PROXY, and it
+// additionally honours WithProxyURL overrides.
+func DirectTransport() *http.Transport {
+	directTransportOnce.Do(func() {
+		// The clone keeps Proxy: http.ProxyFromEnvironment as the fallback for requests without an override.
+		directTransport = HonorProxyOverride(http.DefaultTransport.(*http.Transport).Clone())
+	})
+	return directTransport
+}
+
 // SetProxy configures the provided HTTP client with proxy settings from the configuration.
 // It supports SOCKS5, HTTP, and HTTPS proxies. The function modifies the client's transport
-// to route requests through the configured proxy server.
+// to route requests through the configured proxy server, unless a request carries its own
+// proxy via WithProxyURL. A client without a usable proxy and without a transport of its own
+// gets the shared DirectTransport, so per-request overrides work there too.
 func SetProxy(cfg *config.SDKConfig, httpClient *http.Client) *http.Client {
 	var transport *http.Transport
 	// Attempt to parse the proxy URL from the configuration.
@@ -37,19 +136,41 @@ func SetProxy(cfg *config.SDKConfig, httpClient *http.Client) *http.Client {
 				return httpClient
 			}
 			// Set up a custom transport using the SOCKS5 dialer.
-			transport = &http.Transport{
+			transport = HonorProxyOverride(&http.Transport{
 				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					// Dial with the request context so a cancelled request also aborts the SOCKS5 handshake.
+					if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+						return contextDialer.DialContext(ctx, network, addr)
+					}
 					return dialer.Dial(network, addr)
 				},
-			}
+			})
 		} else if proxyURL.Scheme == "http" || proxyURL.Scheme == "https" {
 			// Configure HTTP or HTTPS proxy.
-			transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+			transport = HonorProxyOverride(&http.Transport{Proxy: http.ProxyURL(proxyURL)})
 		}
 	}
+	if transport == nil && httpClient.Transport == nil {
+		transport = DirectTransport()
+	}
 	// If a new transport was created, apply it to the HTTP client.
 	if transport != nil {
 		httpClient.Transport = transport
 	}
 	return httpClient
 }
+
+// NewHTTPClient builds an HTTP client from the SDK configuration and is the canonical
+// constructor for outbound clients. It applies the configured proxy (see SetProxy) and then
+// HTTPClientTimeout, so the timeout always governs the final transport. A nil configuration
+// yields a client on the shared DirectTransport without a timeout.
+func NewHTTPClient(cfg *config.SDKConfig) *http.Client {
+	if cfg == nil {
+		return &http.Client{Transport: DirectTransport()}
+	}
+	httpClient := SetProxy(cfg, &http.Client{})
+	if cfg.HTTPClientTimeout > 0 {
+		httpClient.Timeout = time.Duration(cfg.HTTPClientTimeout) * time.Second
+	}
+	return httpClient
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// sharedRand backs the randomized retry and backoff decisions. math/rand.Rand is not safe
+// for concurrent use, so every access goes through sharedRandMu.
+var (
+	sharedRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	sharedRandMu sync.Mutex
+)
+
+// SeedRandom reseeds the shared random source used for retry jitter.
+// It exists for tests that need reproducible sequences; production code should not call it.
+func SeedRandom(seed int64) {
+	sharedRandMu.Lock()
+	sharedRand = rand.New(rand.NewSource(seed))
+	sharedRandMu.Unlock()
+}
+
+// RandomInt63n returns a non-negative pseudo-random number in [0, n) from the shared source.
+// It returns 0 when n <= 0.
+func RandomInt63n(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	sharedRandMu.Lock()
+	defer sharedRandMu.Unlock()
+	return sharedRand.Int63n(n)
+}
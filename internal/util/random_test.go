@@ -0,0 +1,26 @@
+package util
+
+import "testing"
+
+func TestSeedRandom_Reproducible(t *testing.T) {
+	sequence := func() []int64 {
+		SeedRandom(42)
+		values := make([]int64, 16)
+		for i := range values {
+			values[i] = RandomInt63n(1000)
+		}
+		return values
+	}
+
+	first := sequence()
+	second := sequence()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected identical sequences for the same seed, differ at %d: %v vs %v", i, first, second)
+		}
+	}
+
+	if got := RandomInt63n(0); got != 0 {
+		t.Errorf("Expected 0 for n <= 0, got %d", got)
+	}
+}
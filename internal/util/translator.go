@@ -6,7 +6,6 @@ package util
 import (
 	"bytes"
 	"fmt"
-	"strings"
 
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
@@ -33,8 +32,7 @@ func Walk(value gjson.Result, path, field string, paths *[]string) {
 			// . -> \.
 			// * -> \*
 			// ? -> \?
-			var keyReplacer = strings.NewReplacer(".", "\\.", "*", "\\*", "?", "\\?")
-			safeKey := keyReplacer.Replace(key.String())
+			safeKey := escapeGJSONPathKey(key.String())
 
 			if path == "" {
 				childPath = safeKey
@@ -11,6 +11,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,6 +20,7 @@ import (
 	. "github.com/router-for-me/CLIProxyAPI/v6/internal/constant"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/interfaces"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
+	geminicommon "github.com/router-for-me/CLIProxyAPI/v6/internal/translator/gemini/common"
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/api/handlers"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
@@ -114,7 +116,7 @@ func (h *ClaudeCodeAPIHandler) ClaudeCountTokens(c *gin.Context) {
 
 	resp, errMsg := h.ExecuteCountWithAuthManager(cliCtx, h.HandlerType(), modelName, rawJSON, alt)
 	if errMsg != nil {
-		h.WriteErrorResponse(c, errMsg)
+		h.WriteErrorResponse(c, claudeErrorMessage(errMsg))
 		cliCancel(errMsg.Error)
 		return
 	}
@@ -153,7 +155,7 @@ func (h *ClaudeCodeAPIHandler) handleNonStreamingResponse(c *gin.Context, rawJSO
 	resp, errMsg := h.ExecuteWithAuthManager(cliCtx, h.HandlerType(), modelName, rawJSON, alt)
 	stopKeepAlive()
 	if errMsg != nil {
-		h.WriteErrorResponse(c, errMsg)
+		h.WriteErrorResponse(c, claudeErrorMessage(errMsg))
 		cliCancel(errMsg.Error)
 		return
 	}
@@ -231,7 +233,7 @@ func (h *ClaudeCodeAPIHandler) handleStreamingResponse(c *gin.Context, rawJSON [
 				continue
 			}
 			// Upstream failed immediately. Return proper error status and JSON.
-			h.WriteErrorResponse(c, errMsg)
+			h.WriteErrorResponse(c, claudeErrorMessage(errMsg))
 			if errMsg != nil {
 				cliCancel(errMsg.Error)
 			} else {
@@ -281,7 +283,13 @@ func (h *ClaudeCodeAPIHandler) forwardClaudeStream(c *gin.Context, flusher http.
 			}
 			c.Status(status)
 
-			errorBytes, _ := json.Marshal(h.toClaudeError(errMsg))
+			var errorBytes []byte
+			if converted := claudeErrorMessage(errMsg); converted != errMsg {
+				// Gemini error bodies were already rewritten into a Claude error body.
+				errorBytes = []byte(converted.Error.Error())
+			} else {
+				errorBytes, _ = json.Marshal(h.toClaudeError(errMsg))
+			}
 			_, _ = fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", errorBytes)
 		},
 	})
@@ -306,3 +314,16 @@ func (h *ClaudeCodeAPIHandler) toClaudeError(msg *interfaces.ErrorMessage) claud
 		},
 	}
 }
+
+// claudeErrorMessage rewrites Gemini-shaped upstream error bodies (quota, safety blocks, ...)
+// into Claude's error shape so Claude clients can handle them. Other errors are returned as-is.
+func claudeErrorMessage(msg *interfaces.ErrorMessage) *interfaces.ErrorMessage {
+	if msg == nil || msg.Error == nil {
+		return msg
+	}
+	converted, ok := geminicommon.ConvertGeminiErrorToClaude(msg.StatusCode, []byte(msg.Error.Error()))
+	if !ok {
+		return msg
+	}
+	return &interfaces.ErrorMessage{StatusCode: msg.StatusCode, Error: errors.New(string(converted)), Addon: msg.Addon}
+}
@@ -65,7 +65,7 @@ func (AntigravityAuthenticator) Login(ctx context.Context, cfg *config.Config, o
 		callbackPort = opts.CallbackPort
 	}
 
-	httpClient := util.SetProxy(&cfg.SDKConfig, &http.Client{})
+	httpClient := util.NewHTTPClient(&cfg.SDKConfig)
 
 	state, err := misc.GenerateRandomState()
 	if err != nil {
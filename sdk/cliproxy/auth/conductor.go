@@ -118,6 +118,8 @@ type Manager struct {
 	// Retry controls request retry behavior.
 	requestRetry     atomic.Int32
 	maxRetryInterval atomic.Int64
+	retryBaseDelay   atomic.Int64
+	retryMaxDelay    atomic.Int64
 
 	// oauthModelAlias stores global OAuth model alias mappings (alias -> upstream name) keyed by channel.
 	oauthModelAlias atomic.Value
@@ -375,6 +377,22 @@ func (m *Manager) SetRetryConfig(retry int, maxRetryInterval time.Duration) {
 	m.maxRetryInterval.Store(maxRetryInterval.Nanoseconds())
 }
 
+// SetRetryBackoff configures exponential backoff between retries: the n-th retry waits about
+// base*2^n, capped at maxDelay. A base <= 0 disables backoff, so retries only wait for cooldowns.
+func (m *Manager) SetRetryBackoff(base, maxDelay time.Duration) {
+	if m == nil {
+		return
+	}
+	if base < 0 {
+		base = 0
+	}
+	if maxDelay < 0 {
+		maxDelay = 0
+	}
+	m.retryBaseDelay.Store(base.Nanoseconds())
+	m.retryMaxDelay.Store(maxDelay.Nanoseconds())
+}
+
 // RegisterExecutor registers a provider executor with the manager.
 func (m *Manager) RegisterExecutor(executor ProviderExecutor) {
 	if executor == nil {
@@ -1226,16 +1244,90 @@ func (m *Manager) shouldRetryAfterError(err error, attempt, maxAttempts int, pro
 	if maxWait <= 0 {
 		return 0, false
 	}
-	if status := statusCodeFromError(err); status == http.StatusOK {
+	status := statusCodeFromError(err)
+	if status == http.StatusOK {
 		return 0, false
 	}
 	wait, found := m.closestCooldownWait(providers, model)
+	// An upstream Retry-After hint is a lower bound: never retry before it elapses.
+	if retryAfter := retryAfterFromError(err); retryAfter != nil {
+		if *retryAfter > wait {
+			wait = *retryAfter
+		}
+		found = true
+	}
+	backoff := m.retryBackoff(attempt, maxWait)
+	if backoff > 0 && (found || isRetryableStatus(status)) {
+		if backoff > wait {
+			return backoff, true
+		}
+		found = true
+	}
 	if !found || wait > maxWait {
 		return 0, false
 	}
+	if backoff > 0 {
+		return jitterRetryWait(wait, maxWait), true
+	}
 	return wait, true
 }
 
+// retryBackoff returns the jittered exponential backoff before retry number attempt+1, or 0 when
+// backoff is disabled. The delay is drawn from [d/2, d] with d = base*2^attempt capped at the
+// configured maximum and maxWait, so until the cap is reached later attempts never wait less
+// than earlier ones.
+func (m *Manager) retryBackoff(attempt int, maxWait time.Duration) time.Duration {
+	base := time.Duration(m.retryBaseDelay.Load())
+	if base <= 0 {
+		return 0
+	}
+	limit := maxWait
+	if maxDelay := time.Duration(m.retryMaxDelay.Load()); maxDelay > 0 && (limit <= 0 || maxDelay < limit) {
+		limit = maxDelay
+	}
+	delay := base
+	for i := 0; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if limit > 0 && delay > limit {
+		delay = limit
+	}
+	half := delay / 2
+	return half + time.Duration(util.RandomInt63n(int64(delay-half)+1))
+}
+
+// isRetryableStatus reports whether an upstream status is worth retrying without a cooldown.
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryJitterFraction bounds the random delay added on top of a cooldown wait, as a fraction
+// of the wait itself, so that requests blocked on the same credential do not retry in lockstep.
+const retryJitterFraction = 0.2
+
+// jitterRetryWait adds up to retryJitterFraction of wait as random jitter, never exceeding maxWait.
+// It is only applied when retry backoff is configured. The cooldown itself (which already reflects upstream Retry-After hints) is always honored.
+func jitterRetryWait(wait, maxWait time.Duration) time.Duration {
+	if wait <= 0 {
+		return wait
+	}
+	span := int64(float64(wait) * retryJitterFraction)
+	if span <= 0 {
+		return wait
+	}
+	jittered := wait + time.Duration(util.RandomInt63n(span+1))
+	if maxWait > 0 && jittered > maxWait {
+		return maxWait
+	}
+	return jittered
+}
+
 func waitForCooldown(ctx context.Context, wait time.Duration) error {
 	if wait <= 0 {
 		return nil
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+)
+
+func TestJitterRetryWait_HonorsCooldownAndCap(t *testing.T) {
+	wait := 10 * time.Second
+	maxWait := time.Minute
+	sawJitter := false
+	for i := 0; i < 200; i++ {
+		got := jitterRetryWait(wait, maxWait)
+		if got < wait {
+			t.Fatalf("Jittered wait %v must not be shorter than cooldown %v", got, wait)
+		}
+		if got > wait+2*time.Second {
+			t.Fatalf("Jittered wait %v exceeds jitter bound", got)
+		}
+		if got != wait {
+			sawJitter = true
+		}
+	}
+	if !sawJitter {
+		t.Error("Expected jitter to vary the wait")
+	}
+
+	if got := jitterRetryWait(wait, wait); got != wait {
+		t.Errorf("Expected wait capped at maxWait %v, got %v", wait, got)
+	}
+	if got := jitterRetryWait(0, maxWait); got != 0 {
+		t.Errorf("Expected zero wait to stay zero, got %v", got)
+	}
+}
+
+func TestJitterRetryWait_SeededIsReproducible(t *testing.T) {
+	wait := 10 * time.Second
+	util.SeedRandom(7)
+	first := jitterRetryWait(wait, time.Minute)
+	util.SeedRandom(7)
+	if second := jitterRetryWait(wait, time.Minute); second != first {
+		t.Errorf("Expected same jitter for the same seed, got %v and %v", first, second)
+	}
+}
+
+type retryTestError struct {
+	status     int
+	retryAfter *time.Duration
+}
+
+func (e retryTestError) Error() string              { return "upstream error" }
+func (e retryTestError) StatusCode() int            { return e.status }
+func (e retryTestError) RetryAfter() *time.Duration { return e.retryAfter }
+
+func TestShouldRetryAfterError_ExponentialBackoff(t *testing.T) {
+	m := NewManager(nil, nil, nil)
+	m.SetRetryBackoff(100*time.Millisecond, time.Second)
+	errUnavailable := retryTestError{status: http.StatusServiceUnavailable}
+
+	var previous time.Duration
+	for attempt := 0; attempt < 6; attempt++ {
+		wait, ok := m.shouldRetryAfterError(errUnavailable, attempt, 10, []string{"gemini"}, "m", time.Minute)
+		if !ok {
+			t.Fatalf("attempt %d: expected a retry", attempt)
+		}
+		ceiling := 100 * time.Millisecond << attempt
+		if ceiling > time.Second {
+			ceiling = time.Second
+		}
+		if wait < ceiling/2 || wait > ceiling {
+			t.Fatalf("attempt %d: wait %v outside [%v, %v]", attempt, wait, ceiling/2, ceiling)
+		}
+		// Equal jitter keeps the sequence non-decreasing until the cap is reached.
+		if ceiling < time.Second && wait < previous {
+			t.Fatalf("attempt %d: wait %v shrank from %v", attempt, wait, previous)
+		}
+		previous = wait
+	}
+
+	if wait, _ := m.shouldRetryAfterError(errUnavailable, 8, 10, []string{"gemini"}, "m", 300*time.Millisecond); wait > 300*time.Millisecond {
+		t.Errorf("Expected backoff capped at max-retry-interval, got %v", wait)
+	}
+	for _, status := range []int{http.StatusBadRequest, http.StatusForbidden} {
+		if _, ok := m.shouldRetryAfterError(retryTestError{status: status}, 0, 10, []string{"gemini"}, "m", time.Minute); ok {
+			t.Errorf("Expected no retry for non-retryable status %d without cooldown", status)
+		}
+	}
+
+	m.SetRetryBackoff(0, 0)
+	if _, ok := m.shouldRetryAfterError(errUnavailable, 0, 10, []string{"gemini"}, "m", time.Minute); ok {
+		t.Error("Expected no retry without backoff or cooldown")
+	}
+}
+
+func TestShouldRetryAfterError_HonorsRetryAfter(t *testing.T) {
+	m := NewManager(nil, nil, nil)
+	m.SetRetryBackoff(100*time.Millisecond, time.Second)
+	retryAfter := 5 * time.Second
+	errLimited := retryTestError{status: http.StatusTooManyRequests, retryAfter: &retryAfter}
+
+	wait, ok := m.shouldRetryAfterError(errLimited, 0, 3, []string{"gemini"}, "m", time.Minute)
+	if !ok {
+		t.Fatal("Expected a retry honoring Retry-After")
+	}
+	if wait < retryAfter || wait > retryAfter+time.Second {
+		t.Errorf("Expected wait of at least Retry-After %v plus jitter, got %v", retryAfter, wait)
+	}
+
+	if _, ok := m.shouldRetryAfterError(errLimited, 0, 3, []string{"gemini"}, "m", 2*time.Second); ok {
+		t.Error("Expected no retry when Retry-After exceeds max-retry-interval")
+	}
+
+	// Without backoff configured the hint alone still triggers a retry, waiting exactly that long.
+	m.SetRetryBackoff(0, 0)
+	if wait, ok := m.shouldRetryAfterError(errLimited, 0, 3, []string{"gemini"}, "m", time.Minute); !ok || wait != retryAfter {
+		t.Errorf("Expected Retry-After honored without jitter when backoff is off, got %v (retry %t)", wait, ok)
+	}
+}
@@ -326,6 +326,7 @@ func (s *Service) applyRetryConfig(cfg *config.Config) {
 	}
 	maxInterval := time.Duration(cfg.MaxRetryInterval) * time.Second
 	s.coreManager.SetRetryConfig(cfg.RequestRetry, maxInterval)
+	s.coreManager.SetRetryBackoff(time.Duration(cfg.RetryBaseDelayMs)*time.Millisecond, time.Duration(cfg.RetryMaxDelayMs)*time.Millisecond)
 }
 
 func openAICompatInfoFromAuth(a *coreauth.Auth) (providerKey string, compatName string, ok bool) {
@@ -0,0 +1,22 @@
+// Package schema re-exports the JSON schema cleaner used for Gemini-family function
+// declarations so SDK consumers can prepare tool schemas the same way the proxy does.
+package schema
+
+import internalutil "github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+
+// Sanitizer cleans a JSON schema string for an upstream that supports only a subset of JSON Schema.
+type Sanitizer = internalutil.SchemaSanitizer
+
+// SanitizerFunc adapts a plain function to the Sanitizer interface.
+type SanitizerFunc = internalutil.SchemaSanitizerFunc
+
+// CleanForGemini rewrites a JSON schema into the subset accepted by Gemini and Antigravity
+// function declarations. Unsupported keywords ($ref, const, anyOf, type arrays, constraints, ...)
+// are removed or flattened and their meaning is preserved as description hints. The input must
+// be a JSON object; the result is always a JSON object and the input is never modified.
+func CleanForGemini(jsonSchema string) string {
+	return internalutil.CleanJSONSchemaForAntigravity(jsonSchema)
+}
+
+// GeminiSanitizer is the Sanitizer backed by CleanForGemini.
+var GeminiSanitizer Sanitizer = internalutil.AntigravitySchemaSanitizer
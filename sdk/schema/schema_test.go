@@ -0,0 +1,34 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCleanForGemini(t *testing.T) {
+	input := `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"properties": {
+			"mode": {"const": "fast"},
+			"note": {"type": ["string", "null"]}
+		},
+		"required": ["mode", "note"]
+	}`
+
+	result := CleanForGemini(input)
+
+	if gjson.Get(result, "$schema").Exists() {
+		t.Errorf("Expected $schema to be removed, got: %s", result)
+	}
+	if got := gjson.Get(result, "properties.mode.enum.0").String(); got != "fast" {
+		t.Errorf("Expected const converted to enum, got: %s", result)
+	}
+	if got := gjson.Get(result, "properties.note.type").String(); got != "string" {
+		t.Errorf("Expected type array flattened to string, got: %s", result)
+	}
+	if result != GeminiSanitizer.Clean(input) {
+		t.Error("Expected GeminiSanitizer to match CleanForGemini")
+	}
+}